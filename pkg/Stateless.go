@@ -4,6 +4,7 @@ import (
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
@@ -12,12 +13,22 @@ import (
 )
 
 // StatelessNavigationManager - навигация без сохранения состояния
-// Путь кодируется прямо в callback_data кнопки "назад"
+// Путь кодируется прямо в callback_data кнопки "назад", поэтому каждое сообщение
+// с меню уже несет свою историю и навигирует независимо от остальных
 type StatelessNavigationManager struct {
 	backBtnPrefix string
-	maxPathLength int // Ограничение длины пути в символах
+	maxPathLength int        // Ограничение длины пути в символах
+	codec         *PathCodec // Компактный кодек пути (nil - JSON + base64)
+
+	// Хранилище длинных путей (nil - длинный путь теряется и ведет в главное меню)
+	overflow PathOverflowStore
+
+	envelope callbackEnvelope // Версия и подпись callback_data
 }
 
+// compactPathPrefix отличает пути компактного кодека от JSON формата
+const compactPathPrefix = "~"
+
 // NavigationPath представляет путь навигации
 type NavigationPath struct {
 	Path []string `json:"p"`
@@ -27,9 +38,33 @@ func NewStatelessNavigationManager() *StatelessNavigationManager {
 	return &StatelessNavigationManager{
 		backBtnPrefix: "back:",
 		maxPathLength: 200, // Максимум 200 символов для Telegram callback_data
+		envelope:      newCallbackEnvelope(),
 	}
 }
 
+// SetCallbackVersions задает версионирование callback_data и перенаправления меню
+func (snm *StatelessNavigationManager) SetCallbackVersions(versions *CallbackVersions) {
+	snm.envelope.versions = versions
+}
+
+// SetPathCodec включает компактное кодирование путей
+// Пути в старом JSON формате по-прежнему декодируются
+func (snm *StatelessNavigationManager) SetPathCodec(codec *PathCodec) {
+	snm.codec = codec
+}
+
+// SetSigner включает подпись callback_data секретом сервера
+// Подпись занимает часть из 64 байт, поэтому длинные пути раньше уходят в хранилище
+func (snm *StatelessNavigationManager) SetSigner(signer *CallbackSigner) {
+	snm.envelope.signer = signer
+}
+
+// SetOverflowStore включает хранение путей, не влезающих в callback_data
+// Без хранилища кнопка с хэшем ведет в главное меню
+func (snm *StatelessNavigationManager) SetOverflowStore(store PathOverflowStore) {
+	snm.overflow = store
+}
+
 // CreateBackButton создает кнопку "назад" с закодированным путем
 func (snm *StatelessNavigationManager) CreateBackButton(currentPath []string) *tele.Btn {
 	if len(currentPath) <= 1 {
@@ -44,14 +79,14 @@ func (snm *StatelessNavigationManager) CreateBackButton(currentPath []string) *t
 	callbackData := snm.backBtnPrefix + encodedPath
 
 	// Проверяем ограничение Telegram (64 символа для callback_data)
-	if len(callbackData) > 64 {
+	if len(callbackData) > snm.envelope.callbackLimit() {
 		// Используем хэш для длинных путей
-		hash := snm.hashPath(prevPath)
-		callbackData = snm.backBtnPrefix + "h:" + hash
+		callbackData = snm.backBtnPrefix + "h:" + snm.storeOverflow(prevPath)
 	}
 
 	selector := &tele.ReplyMarkup{}
-	return selector.Data("⬅️ Назад", callbackData)
+	btn := selector.Data("⬅️ Назад", snm.envelope.wrap(callbackData))
+	return &btn
 }
 
 // AddBackButton добавляет кнопку "назад" к клавиатуре
@@ -61,40 +96,76 @@ func (snm *StatelessNavigationManager) AddBackButton(keyboard *tele.ReplyMarkup,
 		return // Нет кнопки назад для корневого уровня
 	}
 
-	if keyboard.InlineKeyboard == nil {
-		keyboard.InlineKeyboard = make([][]tele.Btn, 0)
-	}
-	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tele.Btn{*backBtn})
+	appendButtonRow(keyboard, backBtn)
 }
 
 // IsBackButton проверяет, является ли callback кнопкой "назад"
 func (snm *StatelessNavigationManager) IsBackButton(callbackData string) bool {
-	return strings.HasPrefix(callbackData, snm.backBtnPrefix)
+	return strings.HasPrefix(snm.envelope.peek(callbackData), snm.backBtnPrefix)
 }
 
 // DecodeBackButton декодирует путь из callback кнопки "назад"
+// При включенной подписи неверная подпись возвращает ErrTamperedCallback
 func (snm *StatelessNavigationManager) DecodeBackButton(callbackData string) ([]string, error) {
 	if !snm.IsBackButton(callbackData) {
 		return nil, fmt.Errorf("not a back button")
 	}
 
-	encodedPath := strings.TrimPrefix(callbackData, snm.backBtnPrefix)
+	payload, err := snm.envelope.unwrap(callbackData)
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPath := strings.TrimPrefix(payload, snm.backBtnPrefix)
 
 	// Проверяем, это хэш или обычный путь
 	if strings.HasPrefix(encodedPath, "h:") {
-		// Это хэшированный путь - возвращаем к главному меню
-		return []string{"main"}, nil
+		if snm.overflow == nil {
+			// Хранилища нет - возвращаем к главному меню
+			return []string{"main"}, nil
+		}
+		return snm.loadOverflow(strings.TrimPrefix(encodedPath, "h:"))
 	}
 
 	return snm.decodePath(encodedPath)
 }
 
+// storeOverflow сохраняет длинный путь в хранилище и возвращает его хэш
+func (snm *StatelessNavigationManager) storeOverflow(path []string) string {
+	hash := snm.hashPath(path)
+	if snm.overflow == nil {
+		return hash
+	}
+
+	if err := snm.overflow.Put(hash, path); err != nil {
+		log.Printf("❌ Ошибка сохранения длинного пути %s: %v", hash, err)
+	}
+	return hash
+}
+
+// loadOverflow достает длинный путь из хранилища
+// Если запись вытеснена или истекла, возвращается ErrPathExpired
+func (snm *StatelessNavigationManager) loadOverflow(hash string) ([]string, error) {
+	path, found, err := snm.overflow.Get(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrPathExpired
+	}
+	return path, nil
+}
+
 // encodePath кодирует путь в строку
 func (snm *StatelessNavigationManager) encodePath(path []string) string {
 	if len(path) == 0 {
 		return ""
 	}
 
+	if snm.codec != nil {
+		return compactPathPrefix + snm.codec.Encode(path)
+	}
+
 	pathData := NavigationPath{Path: path}
 	jsonData, err := json.Marshal(pathData)
 	if err != nil {
@@ -119,6 +190,13 @@ func (snm *StatelessNavigationManager) decodePath(encoded string) ([]string, err
 		return []string{}, nil
 	}
 
+	if strings.HasPrefix(encoded, compactPathPrefix) {
+		if snm.codec == nil {
+			return nil, fmt.Errorf("compact path codec is not configured")
+		}
+		return snm.codec.Decode(strings.TrimPrefix(encoded, compactPathPrefix))
+	}
+
 	jsonData, err := base64.URLEncoding.DecodeString(encoded)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base64: %v", err)
@@ -142,9 +220,6 @@ func (snm *StatelessNavigationManager) hashPath(path []string) string {
 
 // CreateMenuButton создает кнопку меню с кодированием следующего пути
 func (snm *StatelessNavigationManager) CreateMenuButton(text, menuID string, currentPath []string) *tele.Btn {
-	// Создаем новый путь с добавлением текущего меню
-	newPath := append(currentPath, menuID)
-
 	selector := &tele.ReplyMarkup{}
 
 	// В callback_data кодируем информацию о переходе
@@ -153,28 +228,50 @@ func (snm *StatelessNavigationManager) CreateMenuButton(text, menuID string, cur
 	callbackData := fmt.Sprintf("menu:%s:%s", menuID, encodedCurrentPath)
 
 	// Проверяем ограничение длины
-	if len(callbackData) > 64 {
-		// Используем сокращенный формат
-		callbackData = fmt.Sprintf("menu:%s", menuID)
+	limit := snm.envelope.callbackLimit()
+	if len(callbackData) > limit {
+		if snm.overflow != nil {
+			// Путь достанем из хранилища по хэшу
+			callbackData = fmt.Sprintf("menu:%s:h:%s", menuID, snm.storeOverflow(currentPath))
+		}
+		if snm.overflow == nil || len(callbackData) > limit {
+			// Используем сокращенный формат
+			callbackData = fmt.Sprintf("menu:%s", menuID)
+		}
 	}
 
-	return selector.Data(text, callbackData)
+	btn := selector.Data(text, snm.envelope.wrap(callbackData))
+	return &btn
 }
 
 // DecodeMenuButton декодирует информацию из кнопки меню
+// При включенной подписи неверная подпись возвращает ErrTamperedCallback
 func (snm *StatelessNavigationManager) DecodeMenuButton(callbackData string) (menuID string, currentPath []string, err error) {
-	if !strings.HasPrefix(callbackData, "menu:") {
+	if !strings.HasPrefix(snm.envelope.peek(callbackData), "menu:") {
 		return "", nil, fmt.Errorf("not a menu button")
 	}
 
-	parts := strings.SplitN(callbackData, ":", 3)
+	payload, err := snm.envelope.unwrap(callbackData)
+	if err != nil {
+		return "", nil, err
+	}
+
+	parts := strings.SplitN(payload, ":", 3)
 	if len(parts) < 2 {
 		return "", nil, fmt.Errorf("invalid menu button format")
 	}
 
 	menuID = parts[1]
 
-	if len(parts) == 3 && parts[2] != "" {
+	if len(parts) == 3 && strings.HasPrefix(parts[2], "h:") && snm.overflow != nil {
+		// Истекший путь не мешает открыть меню - путь начнется заново
+		currentPath, err = snm.loadOverflow(strings.TrimPrefix(parts[2], "h:"))
+		if err != nil {
+			log.Printf("⚠️  Путь для меню %s потерян: %v", menuID, err)
+			currentPath = []string{}
+			err = nil
+		}
+	} else if len(parts) == 3 && parts[2] != "" {
 		currentPath, err = snm.decodePath(parts[2])
 		if err != nil {
 			// Если не удалось декодировать, используем пустой путь
@@ -187,3 +284,61 @@ func (snm *StatelessNavigationManager) DecodeMenuButton(callbackData string) (me
 
 	return menuID, currentPath, nil
 }
+
+// MenuButton реализует Navigator: путь экрана from кодируется в кнопку
+func (snm *StatelessNavigationManager) MenuButton(from Screen, text, menuID string) *tele.Btn {
+	return snm.CreateMenuButton(text, menuID, from.Path)
+}
+
+// BackButton реализует Navigator: предыдущий путь кодируется в кнопку
+func (snm *StatelessNavigationManager) BackButton(screen Screen) *tele.Btn {
+	return snm.CreateBackButton(screen.Path)
+}
+
+// Open реализует Navigator: весь путь хранится в кнопках, запоминать нечего
+func (snm *StatelessNavigationManager) Open(screen Screen) error {
+	return nil
+}
+
+// Back реализует Navigator: возвращаемся по пути экрана
+func (snm *StatelessNavigationManager) Back(screen Screen) (Screen, bool, error) {
+	parent, ok := ParentScreen(screen)
+	return parent, ok, nil
+}
+
+// Resolve реализует Navigator: декодирует путь из "back:" и "menu:"
+func (snm *StatelessNavigationManager) Resolve(scope NavigationScope, callbackData string) (Screen, bool, error) {
+	data := normalizeCallbackData(callbackData)
+
+	if snm.IsBackButton(data) {
+		path, err := snm.DecodeBackButton(data)
+		if errors.Is(err, ErrPathExpired) || errors.Is(err, ErrStalePath) {
+			// Запись о длинном пути истекла или меню перезагружено - честно возвращаем в главное меню
+			log.Printf("⚠️  Путь назад для %v истек, возвращаем в главное меню", scope)
+			path, err = []string{"main"}, nil
+		}
+		if err != nil {
+			return Screen{}, true, err
+		}
+		if len(path) == 0 {
+			path = []string{"main"}
+		}
+		path = snm.envelope.migratePath(path)
+		return Screen{Scope: scope, MenuID: path[len(path)-1], Path: path}, true, nil
+	}
+
+	if strings.HasPrefix(snm.envelope.peek(data), "menu:") {
+		menuID, currentPath, err := snm.DecodeMenuButton(data)
+		if err != nil {
+			return Screen{}, true, err
+		}
+		menuID, currentPath = snm.envelope.migrateMenu(menuID), snm.envelope.migratePath(currentPath)
+		if len(currentPath) == 0 {
+			// Путь неизвестен - его восстановит реестр меню
+			return Screen{Scope: scope, MenuID: menuID}, true, nil
+		}
+		return NewScreen(Screen{Scope: scope, Path: currentPath}, menuID), true, nil
+	}
+
+	return Screen{}, false, nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+// CallbackSigner подписывает callback_data усеченным HMAC-SHA256
+// Модифицированный клиент не сможет подделать переход на произвольный экран
+type CallbackSigner struct {
+	secret []byte
+	tagLen int // Длина подписи в символах base64url
+}
+
+// telegramCallbackLimit - максимальный размер callback_data в Telegram
+const telegramCallbackLimit = 64
+
+// callbackSignatureSeparator отделяет подпись от полезной нагрузки
+const callbackSignatureSeparator = "|"
+
+// ErrTamperedCallback - подпись callback_data отсутствует или не совпадает
+var ErrTamperedCallback = errors.New("callback data signature mismatch")
+
+func NewCallbackSigner(secret []byte) *CallbackSigner {
+	return &CallbackSigner{
+		secret: secret,
+		tagLen: 8, // 48 бит - достаточно против перебора через Telegram и оставляет место под путь
+	}
+}
+
+// Sign добавляет подпись к полезной нагрузке: "<payload>|<tag>"
+func (cs *CallbackSigner) Sign(payload string) string {
+	return payload + callbackSignatureSeparator + cs.tag(payload)
+}
+
+// Verify проверяет подпись и возвращает полезную нагрузку
+func (cs *CallbackSigner) Verify(data string) (string, error) {
+	idx := strings.LastIndex(data, callbackSignatureSeparator)
+	if idx < 0 {
+		return "", ErrTamperedCallback
+	}
+
+	payload, tag := data[:idx], data[idx+len(callbackSignatureSeparator):]
+	if !hmac.Equal([]byte(tag), []byte(cs.tag(payload))) {
+		return "", ErrTamperedCallback
+	}
+	return payload, nil
+}
+
+// Overhead возвращает, сколько байт подпись добавляет к callback_data
+func (cs *CallbackSigner) Overhead() int {
+	return len(callbackSignatureSeparator) + cs.tagLen
+}
+
+// tag вычисляет усеченный HMAC полезной нагрузки
+func (cs *CallbackSigner) tag(payload string) string {
+	mac := hmac.New(sha256.New, cs.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))[:cs.tagLen]
+}
+
+// callbackEnvelope - общая обертка callback_data: метка версии и подпись
+// Формат: "v<версия>:<payload>|<подпись>", подпись добавляется только при включенном подписчике
+type callbackEnvelope struct {
+	signer   *CallbackSigner
+	versions *CallbackVersions
+}
+
+func newCallbackEnvelope() callbackEnvelope {
+	return callbackEnvelope{versions: NewCallbackVersions()}
+}
+
+// wrap упаковывает полезную нагрузку в callback_data
+func (ce *callbackEnvelope) wrap(payload string) string {
+	if ce.versions != nil {
+		payload = ce.versions.Tag(payload)
+	}
+	if ce.signer != nil {
+		payload = ce.signer.Sign(payload)
+	}
+	return payload
+}
+
+// unwrap проверяет callback_data и возвращает полезную нагрузку в текущем формате
+func (ce *callbackEnvelope) unwrap(data string) (string, error) {
+	payload := data
+	if ce.signer != nil {
+		var err error
+		if payload, err = ce.signer.Verify(data); err != nil {
+			return "", err
+		}
+	}
+	if ce.versions != nil {
+		return ce.versions.Decode(payload)
+	}
+	return payload, nil
+}
+
+// peek снимает подпись и метку версии без проверки - только чтобы понять тип кнопки
+func (ce *callbackEnvelope) peek(data string) string {
+	if ce.signer != nil {
+		if idx := strings.LastIndex(data, callbackSignatureSeparator); idx >= 0 {
+			data = data[:idx]
+		}
+	}
+	if ce.versions != nil {
+		data = ce.versions.Strip(data)
+	}
+	return data
+}
+
+// overhead возвращает размер служебных данных обертки
+func (ce *callbackEnvelope) overhead() int {
+	size := 0
+	if ce.versions != nil {
+		size += ce.versions.Overhead()
+	}
+	if ce.signer != nil {
+		size += ce.signer.Overhead()
+	}
+	return size
+}
+
+// callbackLimit возвращает, сколько байт полезной нагрузки влезает в callback_data кнопки
+// Из 64 байт Telegram вычитаются "\f", который telebot ставит перед unique, и служебные данные обертки
+func (ce *callbackEnvelope) callbackLimit() int {
+	return telegramCallbackLimit - len("\f") - ce.overhead()
+}
+
+// migrateMenu перенаправляет устаревший ID меню
+func (ce *callbackEnvelope) migrateMenu(menuID string) string {
+	if ce.versions == nil {
+		return menuID
+	}
+	return ce.versions.MigrateMenu(menuID)
+}
+
+// migratePath перенаправляет устаревшие ID меню в пути
+func (ce *callbackEnvelope) migratePath(path []string) []string {
+	if ce.versions == nil {
+		return path
+	}
+	return ce.versions.MigratePath(path)
+}
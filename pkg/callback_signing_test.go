@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCallbackSignerVerify(t *testing.T) {
+	signer := NewCallbackSigner([]byte("secret"))
+	data := signer.Sign("menu:settings")
+
+	if got := len(data) - len("menu:settings"); got != signer.Overhead() {
+		t.Errorf("overhead = %d, signature takes %d", signer.Overhead(), got)
+	}
+	if payload, err := signer.Verify(data); err != nil || payload != "menu:settings" {
+		t.Fatalf("verify = %q, %v", payload, err)
+	}
+
+	forged := []string{
+		"menu:admin" + data[len("menu:settings"):],        // Подмена полезной нагрузки
+		NewCallbackSigner([]byte("other")).Sign("menu:x"), // Чужой секрет
+		"menu:settings",          // Без подписи
+		data[:len(data)-1] + "A", // Испорченная подпись
+	}
+	for _, data := range forged {
+		if _, err := signer.Verify(data); !errors.Is(err, ErrTamperedCallback) {
+			t.Errorf("verify %q = %v, want ErrTamperedCallback", data, err)
+		}
+	}
+}
+
+func TestStatelessRejectsTamperedButton(t *testing.T) {
+	snm := NewStatelessNavigationManager()
+	snm.SetSigner(NewCallbackSigner([]byte("secret")))
+
+	btn := snm.CreateMenuButton("Настройки", "settings", []string{"main"})
+	if _, _, err := snm.Resolve(NavigationScope{}, btn.Unique); err != nil {
+		t.Fatalf("resolve signed button: %v", err)
+	}
+
+	tampered := strings.Replace(btn.Unique, "settings", "admin", 1)
+	if _, _, err := snm.Resolve(NavigationScope{}, tampered); !errors.Is(err, ErrTamperedCallback) {
+		t.Errorf("resolve tampered button = %v, want ErrTamperedCallback", err)
+	}
+}
+
+// TestCallbackLimitBoundary проверяет границу 64 байт вместе с "\f", который добавляет telebot
+func TestCallbackLimitBoundary(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		snm := NewStatelessNavigationManager()
+		if signed {
+			snm.SetSigner(NewCallbackSigner([]byte("secret")))
+		}
+		path := []string{"main"}
+		fixed := len(snm.envelope.wrap("menu::" + snm.encodePath(path)))
+
+		for _, size := range []int{63, 64} {
+			menuID := strings.Repeat("m", size-fixed)
+			btn := snm.CreateMenuButton("Меню", menuID, path)
+			callback := "\f" + btn.Unique
+
+			if len(callback) > telegramCallbackLimit {
+				t.Errorf("signed=%v, %d bytes: callback_data is %d bytes", signed, size, len(callback))
+			}
+			_, kept, err := snm.DecodeMenuButton(btn.Unique)
+			if err != nil {
+				t.Fatalf("signed=%v, %d bytes: decode: %v", signed, size, err)
+			}
+			// 63 байта с "\f" ровно заполняют лимит, 64 - уже нет, путь отбрасывается
+			if wantPath := size == 63; (len(kept) > 0) != wantPath {
+				t.Errorf("signed=%v, %d bytes: path kept = %v, want %v", signed, size, kept, wantPath)
+			}
+		}
+	}
+}
+
+func TestBackButtonsUseEnvelope(t *testing.T) {
+	hn := NewHierarchicalNavigation()
+
+	btn := hn.CreateBackButton("settings")
+	if !strings.HasPrefix(btn.Unique, "v2:nav_back|") {
+		t.Errorf("back button = %q, want versioned", btn.Unique)
+	}
+	for _, data := range []string{btn.Unique, "\fnav_back|settings"} {
+		if screen, _, err := hn.Resolve(NavigationScope{}, data); err != nil || screen.MenuID != "main" {
+			t.Errorf("resolve %q = %q, %v", data, screen.MenuID, err)
+		}
+	}
+
+	// ID меню, не влезающий в callback_data, не попадает в кнопку
+	long := hn.CreateBackButton(strings.Repeat("x", telegramCallbackLimit))
+	if size := len("\f" + long.Unique); size > telegramCallbackLimit {
+		t.Errorf("back button with long menu id is %d bytes", size)
+	}
+
+	pnm := NewPersistentNavigationManagerWithStore(NewMemoryNavigationStore())
+	defer pnm.Close(context.Background())
+
+	scope := UserScope(1)
+	for _, menuID := range []string{"main", "settings"} {
+		if err := pnm.PushMenu(context.Background(), scope, menuID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	persistent := pnm.BackButton(Screen{Scope: scope, MenuID: "settings"})
+	if persistent == nil || persistent.Unique != "v2:persistent_back" {
+		t.Fatalf("persistent back button = %+v", persistent)
+	}
+	if screen, _, err := pnm.Resolve(scope, persistent.Unique); err != nil || screen.MenuID != "main" {
+		t.Errorf("persistent back = %q, %v", screen.MenuID, err)
+	}
+}
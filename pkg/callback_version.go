@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// CallbackVersions - версионирование формата callback_data
+// Кнопки живут в истории чата месяцами, поэтому каждая сгенерированная кнопка
+// помечается версией формата, а старые версии поднимаются цепочкой декодеров
+type CallbackVersions struct {
+	current   int
+	upgraders map[int]func(payload string) (string, error) // версия N -> формат N+1
+	redirects map[string]string                            // устаревший menu_id -> новый
+	migrate   func(menuID string) (string, bool)           // произвольная миграция menu_id
+}
+
+// currentCallbackVersion - текущая версия формата
+// Версия 1 - исходный формат без метки ("back_to:x", "menu:x", "back:...")
+const currentCallbackVersion = 2
+
+// ErrUnsupportedCallbackVersion - кнопка создана более новой версией бота
+var ErrUnsupportedCallbackVersion = errors.New("unsupported callback data version")
+
+// callbackVersionRx разбирает метку версии "v<N>:" в начале callback_data
+var callbackVersionRx = regexp.MustCompile(`^v(\d+):`)
+
+func NewCallbackVersions() *CallbackVersions {
+	cv := &CallbackVersions{
+		current:   currentCallbackVersion,
+		upgraders: make(map[int]func(string) (string, error)),
+		redirects: make(map[string]string),
+	}
+
+	// Версия 2 добавила только метку, сам формат не изменился
+	cv.RegisterUpgrade(1, func(payload string) (string, error) {
+		return payload, nil
+	})
+
+	return cv
+}
+
+// RegisterUpgrade регистрирует преобразование формата версии from в формат from+1
+func (cv *CallbackVersions) RegisterUpgrade(from int, upgrade func(payload string) (string, error)) {
+	cv.upgraders[from] = upgrade
+}
+
+// RedirectMenu перенаправляет устаревший ID меню на новый
+func (cv *CallbackVersions) RedirectMenu(oldID, newID string) {
+	cv.redirects[oldID] = newID
+}
+
+// SetMenuMigration задает хук миграции ID меню, вызывается после перенаправлений
+// Хук возвращает новый ID и true, если ID нужно заменить
+func (cv *CallbackVersions) SetMenuMigration(migrate func(menuID string) (string, bool)) {
+	cv.migrate = migrate
+}
+
+// Tag помечает полезную нагрузку текущей версией: "v2:<payload>"
+func (cv *CallbackVersions) Tag(payload string) string {
+	return "v" + strconv.Itoa(cv.current) + ":" + payload
+}
+
+// Overhead возвращает размер метки версии
+func (cv *CallbackVersions) Overhead() int {
+	return len(cv.Tag(""))
+}
+
+// Decode снимает метку версии и поднимает полезную нагрузку до текущего формата
+func (cv *CallbackVersions) Decode(data string) (string, error) {
+	version, payload := cv.split(data)
+	if version > cv.current {
+		return "", fmt.Errorf("%w: v%d", ErrUnsupportedCallbackVersion, version)
+	}
+
+	for ; version < cv.current; version++ {
+		upgrade, exists := cv.upgraders[version]
+		if !exists {
+			return "", fmt.Errorf("%w: no upgrade from v%d", ErrUnsupportedCallbackVersion, version)
+		}
+
+		var err error
+		if payload, err = upgrade(payload); err != nil {
+			return "", fmt.Errorf("upgrade callback from v%d: %v", version, err)
+		}
+	}
+
+	return payload, nil
+}
+
+// Strip снимает метку версии без преобразования формата (для классификации кнопок)
+func (cv *CallbackVersions) Strip(data string) string {
+	_, payload := cv.split(data)
+	return payload
+}
+
+// MigrateMenu применяет перенаправления и хук миграции к ID меню
+func (cv *CallbackVersions) MigrateMenu(menuID string) string {
+	// Перенаправления могут идти цепочкой: a -> b -> c
+	for seen := map[string]bool{}; !seen[menuID]; {
+		seen[menuID] = true
+		newID, exists := cv.redirects[menuID]
+		if !exists {
+			break
+		}
+		menuID = newID
+	}
+
+	if cv.migrate != nil {
+		if newID, ok := cv.migrate(menuID); ok {
+			menuID = newID
+		}
+	}
+	return menuID
+}
+
+// MigratePath применяет MigrateMenu к каждому элементу пути
+func (cv *CallbackVersions) MigratePath(path []string) []string {
+	migrated := make([]string, len(path))
+	for i, menuID := range path {
+		migrated[i] = cv.MigrateMenu(menuID)
+	}
+	return migrated
+}
+
+// split отделяет метку версии, данные без метки считаются версией 1
+func (cv *CallbackVersions) split(data string) (int, string) {
+	match := callbackVersionRx.FindStringSubmatch(data)
+	if match == nil {
+		return 1, data
+	}
+
+	version, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 1, data
+	}
+	return version, data[len(match[0]):]
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCallbackVersionsUpgradeChain(t *testing.T) {
+	cv := NewCallbackVersions()
+
+	// Кнопки версии 1 хранили путь возврата как "back_to:<menu>"
+	cv.RegisterUpgrade(1, func(payload string) (string, error) {
+		if strings.HasPrefix(payload, "back_to:") {
+			return "menu:" + strings.TrimPrefix(payload, "back_to:"), nil
+		}
+		return payload, nil
+	})
+
+	for data, want := range map[string]string{
+		"back_to:settings":  "menu:settings", // Без метки - версия 1
+		"v1:back_to:help":   "menu:help",
+		"v2:back_to:help":   "back_to:help", // Текущая версия не преобразуется
+		cv.Tag("menu:main"): "menu:main",
+	} {
+		if payload, err := cv.Decode(data); err != nil || payload != want {
+			t.Errorf("decode %q = %q, %v, want %q", data, payload, err, want)
+		}
+	}
+
+	if _, err := cv.Decode("v3:menu:main"); !errors.Is(err, ErrUnsupportedCallbackVersion) {
+		t.Errorf("decode from a newer bot = %v, want ErrUnsupportedCallbackVersion", err)
+	}
+
+	// Следующая версия формата: декодеры применяются по цепочке 1 -> 2 -> 3
+	cv.current = 3
+	if _, err := cv.Decode("back_to:settings"); !errors.Is(err, ErrUnsupportedCallbackVersion) {
+		t.Errorf("decode without a v2 upgrade = %v, want ErrUnsupportedCallbackVersion", err)
+	}
+	cv.RegisterUpgrade(2, func(payload string) (string, error) {
+		return strings.Replace(payload, "menu:", "open:", 1), nil
+	})
+	if payload, err := cv.Decode("back_to:settings"); err != nil || payload != "open:settings" {
+		t.Errorf("decode v1 through the chain = %q, %v", payload, err)
+	}
+	if tagged := cv.Tag("open:main"); tagged != "v3:open:main" {
+		t.Errorf("tag = %q", tagged)
+	}
+}
+
+func TestCallbackVersionsUpgradeError(t *testing.T) {
+	cv := NewCallbackVersions()
+	cv.RegisterUpgrade(1, func(payload string) (string, error) {
+		return "", errors.New("unknown v1 button")
+	})
+
+	if _, err := cv.Decode("menu:main"); err == nil || !strings.Contains(err.Error(), "unknown v1 button") {
+		t.Errorf("decode = %v, want upgrade error", err)
+	}
+}
+
+func TestRedirectMenuInResolve(t *testing.T) {
+	versions := NewCallbackVersions()
+	versions.RedirectMenu("profile", "account")
+	versions.RedirectMenu("account", "user") // Переименование в два шага
+	versions.RedirectMenu("prefs", "settings")
+
+	// Кнопки из истории чата созданы до переименований
+	old := NewStatelessNavigationManager()
+	menuBtn := old.CreateMenuButton("Профиль", "profile", []string{"main", "prefs"})
+	backBtn := old.CreateBackButton([]string{"main", "prefs", "profile"})
+
+	snm := NewStatelessNavigationManager()
+	snm.SetCallbackVersions(versions)
+
+	screen, ok, err := snm.Resolve(NavigationScope{}, menuBtn.Unique)
+	if err != nil || !ok {
+		t.Fatalf("resolve menu button = %v, %v", ok, err)
+	}
+	if screen.MenuID != "user" || !reflect.DeepEqual(screen.Path, []string{"main", "settings", "user"}) {
+		t.Errorf("menu button opened %q with path %v", screen.MenuID, screen.Path)
+	}
+
+	screen, ok, err = snm.Resolve(NavigationScope{}, backBtn.Unique)
+	if err != nil || !ok {
+		t.Fatalf("resolve back button = %v, %v", ok, err)
+	}
+	if screen.MenuID != "settings" || !reflect.DeepEqual(screen.Path, []string{"main", "settings"}) {
+		t.Errorf("back button opened %q with path %v", screen.MenuID, screen.Path)
+	}
+}
+
+func TestRedirectMenuInPersistentBack(t *testing.T) {
+	ctx := context.Background()
+	pnm := NewPersistentNavigationManagerWithStore(NewMemoryNavigationStore())
+	t.Cleanup(func() { pnm.Close(context.Background()) })
+
+	// Стек записан до переименования меню
+	for _, menuID := range []string{"main", "prefs", "privacy"} {
+		if err := pnm.PushMenu(ctx, UserScope(1), menuID); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	versions := NewCallbackVersions()
+	versions.RedirectMenu("prefs", "settings")
+	versions.SetMenuMigration(func(menuID string) (string, bool) {
+		if menuID == "privacy" {
+			return "settings_privacy", true
+		}
+		return "", false
+	})
+	pnm.SetCallbackVersions(versions)
+
+	screen, ok, err := pnm.Resolve(UserScope(1), pnm.createBackButton().Unique)
+	if err != nil || !ok || screen.MenuID != "settings" {
+		t.Errorf("back = %q, %v, %v, want settings", screen.MenuID, ok, err)
+	}
+
+	screen, ok, err = pnm.Resolve(UserScope(1), pnm.MenuButton(Screen{}, "Приватность", "privacy").Unique)
+	if err != nil || !ok || screen.MenuID != "settings_privacy" {
+		t.Errorf("menu button = %q, %v, %v, want settings_privacy", screen.MenuID, ok, err)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen - хранилище помечено недоступным, запрос не отправлялся
+// Оборачивается в StoreError с видом ErrStoreUnavailable
+var ErrCircuitOpen = errors.New("navigation store circuit is open")
+
+// BreakerState - состояние предохранителя хранилища
+type BreakerState int
+
+const (
+	BreakerClosed   BreakerState = iota // Хранилище работает
+	BreakerOpen                         // Хранилище недоступно, навигация только в памяти
+	BreakerHalfOpen                     // Пробный запрос после паузы
+)
+
+func (bs BreakerState) String() string {
+	switch bs {
+	case BreakerClosed:
+		return "closed"
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
+
+// circuitBreaker - предохранитель вокруг NavigationStore
+// После threshold ошибок подряд хранилище не опрашивается cooldown,
+// затем пропускается один пробный запрос: успех закрывает предохранитель, ошибка открывает снова.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	state    BreakerState
+	failures int
+	openedAt time.Time
+	probeAt  time.Time // Когда пропущен пробный запрос, нулевое - проба свободна
+	trips    int64
+	mutex    sync.Mutex
+
+	onChange func(from, to BreakerState)
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		onChange: func(from, to BreakerState) {
+			switch {
+			case to == BreakerOpen && from == BreakerClosed:
+				log.Printf("⚠️  Хранилище навигации недоступно, навигация только в памяти")
+			case to == BreakerClosed:
+				log.Printf("✅ Хранилище навигации снова доступно")
+			}
+		},
+	}
+}
+
+// Allow сообщает, можно ли обращаться к хранилищу
+// В полуоткрытом состоянии пропускается только один пробный запрос. Если его результат
+// так и не учтен (Record), через cooldown пропускается следующий.
+func (cb *circuitBreaker) Allow() bool {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	switch cb.state {
+	case BreakerClosed:
+		return true
+	case BreakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return false
+		}
+		cb.setState(BreakerHalfOpen)
+	}
+
+	if !cb.probeAt.IsZero() && time.Since(cb.probeAt) < cb.cooldown {
+		return false
+	}
+	cb.probeAt = time.Now()
+	return true
+}
+
+// Record учитывает результат обращения к хранилищу
+// Недоступностью считаются только ошибки ErrStoreUnavailable, кроме отмены самим вызывающим
+func (cb *circuitBreaker) Record(err error) {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	// Отмененная проба ничего не сказала о хранилище - пробует следующий запрос
+	cb.probeAt = time.Time{}
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	if !errors.Is(err, ErrStoreUnavailable) {
+		cb.failures = 0
+		if cb.state != BreakerClosed {
+			cb.setState(BreakerClosed)
+		}
+		return
+	}
+
+	cb.failures++
+	if cb.state == BreakerHalfOpen || (cb.state == BreakerClosed && cb.failures >= cb.threshold) {
+		cb.openedAt = time.Now()
+		cb.trips++
+		cb.setState(BreakerOpen)
+	}
+}
+
+// State возвращает текущее состояние
+func (cb *circuitBreaker) State() BreakerState {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return cb.state
+}
+
+func (cb *circuitBreaker) setState(state BreakerState) {
+	from := cb.state
+	cb.state = state
+	if cb.onChange != nil {
+		cb.onChange(from, state)
+	}
+}
+
+// Stats возвращает состояние и счетчики предохранителя
+func (cb *circuitBreaker) Stats() map[string]interface{} {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+
+	return map[string]interface{}{
+		"breaker_state":    cb.state.String(),
+		"breaker_failures": cb.failures,
+		"breaker_trips":    cb.trips,
+		"degraded":         cb.state != BreakerClosed,
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	cb := newCircuitBreaker(1, 10*time.Millisecond)
+	cb.onChange = nil
+
+	cb.Record(storeUnavailable("load", NavigationScope{}, errors.New("down")))
+	if cb.Allow() {
+		t.Fatal("open breaker allowed a request")
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if !cb.Allow() {
+		t.Fatal("half-open breaker rejected the probe")
+	}
+	if cb.Allow() {
+		t.Fatal("half-open breaker allowed a second probe")
+	}
+
+	// Отмененная проба освобождает место для следующей
+	cb.Record(context.Canceled)
+	if !cb.Allow() {
+		t.Fatal("canceled probe was not released")
+	}
+
+	cb.Record(nil)
+	if cb.State() != BreakerClosed || !cb.Allow() || !cb.Allow() {
+		t.Errorf("state after successful probe = %v", cb.State())
+	}
+}
+
+// flakyStore - хранилище в памяти, которое можно отключить
+type flakyStore struct {
+	*MemoryNavigationStore
+	down atomic.Bool
+}
+
+func (fs *flakyStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	if fs.down.Load() {
+		return NavigationRecord{}, storeUnavailable("load", scope, errors.New("connection refused"))
+	}
+	return fs.MemoryNavigationStore.Load(ctx, scope)
+}
+
+func (fs *flakyStore) Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error) {
+	if fs.down.Load() {
+		return 0, storeUnavailable("save", scope, errors.New("connection refused"))
+	}
+	return fs.MemoryNavigationStore.Save(ctx, scope, record)
+}
+
+func newFlakyManager(t *testing.T, stack ...string) (*PersistentNavigationManager, *flakyStore) {
+	t.Helper()
+
+	store := &flakyStore{MemoryNavigationStore: NewMemoryNavigationStore()}
+	if _, err := store.Save(context.Background(), UserScope(1), NavigationRecord{Stack: stack, ClickedAt: time.Now().UTC().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	pnm := NewPersistentNavigationManagerWithStore(store)
+	t.Cleanup(func() { pnm.Close(context.Background()) })
+	return pnm, store
+}
+
+func TestTransientLoadErrorKeepsHistory(t *testing.T) {
+	pnm, store := newFlakyManager(t, "main", "settings")
+	ctx := context.Background()
+
+	store.down.Store(true)
+	if err := pnm.PushMenu(ctx, UserScope(1), "help"); !errors.Is(err, ErrStoreUnavailable) {
+		t.Fatalf("push with a closed breaker = %v, want ErrStoreUnavailable", err)
+	}
+
+	store.down.Store(false)
+	if err := pnm.PushMenu(ctx, UserScope(1), "help"); err != nil {
+		t.Fatal(err)
+	}
+	record, err := pnm.getStackFromCacheOrDB(ctx, UserScope(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main", "settings", "help"}; !reflect.DeepEqual(record.Stack, want) {
+		t.Errorf("stack = %v, want %v", record.Stack, want)
+	}
+}
+
+func TestDegradedStackMergesOnRecovery(t *testing.T) {
+	pnm, store := newFlakyManager(t, "main", "settings")
+	ctx := context.Background()
+
+	store.down.Store(true)
+	for i := 0; i < pnm.breaker.threshold; i++ {
+		pnm.breaker.Record(storeUnavailable("load", UserScope(1), errors.New("connection refused")))
+	}
+	if err := pnm.PushMenu(ctx, UserScope(1), "help"); err != nil {
+		t.Fatalf("push with an open breaker: %v", err)
+	}
+
+	// Хранилище вернулось, пауза предохранителя истекла
+	store.down.Store(false)
+	pnm.breaker.mutex.Lock()
+	pnm.breaker.openedAt = time.Time{}
+	pnm.breaker.mutex.Unlock()
+
+	want := []string{"main", "settings", "help"}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stored, err := store.Load(ctx, UserScope(1))
+		if err == nil && reflect.DeepEqual(stored.Stack, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored stack = %v, %v, want %v", stored.Stack, err, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	record, err := pnm.getStackFromCacheOrDB(ctx, UserScope(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record.Stack, want) || record.Degraded {
+		t.Errorf("cached record = %+v, want stack %v", record, want)
+	}
+}
+
+func TestMergeStacks(t *testing.T) {
+	tests := []struct {
+		stored, ours, want []string
+	}{
+		{[]string{"main", "settings"}, []string{"help"}, []string{"main", "settings", "help"}},
+		{[]string{"main", "help"}, []string{"help", "faq"}, []string{"main", "help", "faq"}},
+		{nil, []string{"help"}, []string{"help"}},
+		{[]string{"a", "b", "c"}, []string{"d", "e"}, []string{"c", "d", "e"}},
+	}
+
+	for _, tt := range tests {
+		if got := mergeStacks(tt.stored, tt.ours, 3); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("mergeStacks(%v, %v) = %v, want %v", tt.stored, tt.ours, got, tt.want)
+		}
+	}
+}
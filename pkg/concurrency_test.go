@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowNavigationStore - хранилище в памяти с задержкой чтения, имитирует запрос к БД
+type slowNavigationStore struct {
+	*MemoryNavigationStore
+	latency time.Duration
+}
+
+func (sns *slowNavigationStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	time.Sleep(sns.latency)
+	return sns.MemoryNavigationStore.Load(ctx, scope)
+}
+
+// BenchmarkConcurrentUsers измеряет PushMenu/PopMenu при разном числе одновременных пользователей
+// Пользователей больше, чем мест в кэше, поэтому часть кликов идет в медленное хранилище:
+//
+//	go test -run '^$' -bench ConcurrentUsers
+func BenchmarkConcurrentUsers(b *testing.B) {
+	for _, users := range []int{100, 1000, 5000} {
+		b.Run(fmt.Sprintf("users=%d", users), func(b *testing.B) {
+			pnm := NewPersistentNavigationManagerWithStore(&slowNavigationStore{
+				MemoryNavigationStore: NewMemoryNavigationStore(),
+				latency:               2 * time.Millisecond,
+			})
+			defer pnm.Close(context.Background())
+
+			ctx := context.Background()
+			var nextUser atomic.Int64
+
+			b.SetParallelism(users/runtime.GOMAXPROCS(0) + 1)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				scope := UserScope(nextUser.Add(1))
+				for i := 0; pb.Next(); i++ {
+					var err error
+					if i%3 == 2 {
+						_, _, err = pnm.PopMenu(ctx, scope)
+					} else {
+						err = pnm.PushMenu(ctx, scope, fmt.Sprintf("menu_%d", i%5))
+					}
+					if err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+			b.StopTimer()
+
+			stats, err := pnm.GetNavigationStats(ctx)
+			if err != nil {
+				b.Fatal(err)
+			}
+			for _, key := range []string{"cache_hits", "cache_misses", "cache_evictions"} {
+				if value, ok := stats[key].(int64); ok {
+					b.ReportMetric(float64(value)/float64(b.N), key+"/op")
+				}
+			}
+		})
+	}
+}
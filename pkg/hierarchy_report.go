@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// hierarchyRoot - корень иерархии по умолчанию, если описание меню не задает свой (root:)
+const hierarchyRoot = "main"
+
+// HierarchyReport - результат проверки иерархии меню
+// Ошибки ломают навигацию и должны останавливать запуск, предупреждения - лишние
+// или недостижимые меню, которые стоит убрать из описания.
+type HierarchyReport struct {
+	Root string // Корень, от которого проверялась иерархия
+
+	// Ошибки
+	Cycles          [][]string       // Меню, родители которых замкнуты в кольцо
+	MissingParents  []HierarchyLink  // Меню, чей родитель не описан в иерархии
+	Orphans         []string         // Меню, от которых по родителям не дойти до корня
+	DanglingButtons []DanglingButton // Кнопки реестра, ведущие в незарегистрированные меню
+
+	// Предупреждения (только при проверке вместе с реестром)
+	Unreachable []string // Меню реестра, в которые не ведет ни одна кнопка от корня
+	Unrendered  []string // Меню иерархии без описания в реестре - их никто не покажет
+}
+
+// HierarchyLink - связь меню с родителем
+type HierarchyLink struct {
+	MenuID   string
+	ParentID string
+}
+
+// DanglingButton - кнопка, ведущая в неизвестное меню
+type DanglingButton struct {
+	MenuID string // Меню, на котором расположена кнопка
+	Text   string
+	Target string
+}
+
+// Validate проверяет иерархию: циклы, несуществующих родителей и меню, оторванные от корня
+// Дополнительные родители (AddParent) проверяются так же, как основные.
+// С реестром menus дополнительно ищет битые кнопки, недостижимые и неотрисовываемые меню,
+// без реестра (nil) проверяется только сама иерархия.
+func (hn *HierarchicalNavigation) Validate(menus *MenuRegistry) HierarchyReport {
+	parents := hn.Parents()
+	root := hn.Root()
+	report := HierarchyReport{Root: root}
+
+	ids := make([]string, 0, len(parents))
+	for menuID := range parents {
+		ids = append(ids, menuID)
+	}
+	sort.Strings(ids)
+
+	children := make(map[string][]string)
+	for _, menuID := range ids {
+		if menuID == root {
+			continue
+		}
+		for _, parentID := range parents[menuID] {
+			children[parentID] = append(children[parentID], menuID)
+			if parentID == root {
+				continue
+			}
+			if _, exists := parents[parentID]; !exists {
+				report.MissingParents = append(report.MissingParents, HierarchyLink{MenuID: menuID, ParentID: parentID})
+			}
+		}
+	}
+
+	// Обход в глубину по родителям: 1 - меню на текущем пути, 2 - все пути из меню разобраны
+	state := make(map[string]int, len(parents))
+	inCycle := make(map[string]bool)
+	reported := make(map[string]bool)
+	var chain []string
+
+	var visit func(menuID string)
+	visit = func(menuID string) {
+		state[menuID] = 1
+		chain = append(chain, menuID)
+
+		for _, parentID := range parents[menuID] {
+			if _, exists := parents[parentID]; !exists || parentID == root {
+				continue
+			}
+			switch state[parentID] {
+			case 0:
+				visit(parentID)
+			case 1:
+				// Вернулись в меню текущего пути - кольцо от него до конца цепочки
+				for i := len(chain) - 1; i >= 0; i-- {
+					if chain[i] != parentID {
+						continue
+					}
+					cycle := normalizeCycle(chain[i:])
+					if key := strings.Join(cycle, " "); !reported[key] {
+						reported[key] = true
+						report.Cycles = append(report.Cycles, cycle)
+					}
+					for _, member := range cycle {
+						inCycle[member] = true
+					}
+					break
+				}
+			}
+		}
+
+		chain = chain[:len(chain)-1]
+		state[menuID] = 2
+	}
+
+	for _, menuID := range ids {
+		if menuID != root && state[menuID] == 0 {
+			visit(menuID)
+		}
+	}
+
+	// От корня вниз: меню связано с корнем, если до него доходит хотя бы один родитель
+	rooted := map[string]bool{root: true}
+	queue := []string{root}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, menuID := range children[current] {
+			if !rooted[menuID] {
+				rooted[menuID] = true
+				queue = append(queue, menuID)
+			}
+		}
+	}
+
+	for _, menuID := range ids {
+		if !rooted[menuID] && !inCycle[menuID] {
+			report.Orphans = append(report.Orphans, menuID)
+		}
+	}
+
+	if menus != nil {
+		report.checkRegistry(menus, hn.Hierarchy())
+	}
+	return report
+}
+
+// checkRegistry сверяет иерархию с кнопками и меню реестра
+func (hr *HierarchyReport) checkRegistry(menus *MenuRegistry, hierarchy map[string]string) {
+	for _, menuID := range menus.order {
+		for _, row := range menus.menus[menuID].Buttons {
+			for _, item := range row {
+				if item.Target == "" {
+					continue
+				}
+				if _, exists := menus.menus[item.Target]; !exists {
+					hr.DanglingButtons = append(hr.DanglingButtons, DanglingButton{MenuID: menuID, Text: item.Text, Target: item.Target})
+				}
+			}
+		}
+	}
+
+	// Обход в ширину по кнопкам от корня
+	reachable := map[string]bool{menus.root: true}
+	queue := []string{menus.root}
+	for len(queue) > 0 {
+		menu, exists := menus.menus[queue[0]]
+		queue = queue[1:]
+		if !exists {
+			continue
+		}
+
+		for _, row := range menu.Buttons {
+			for _, item := range row {
+				if item.Target != "" && !reachable[item.Target] {
+					reachable[item.Target] = true
+					queue = append(queue, item.Target)
+				}
+			}
+		}
+	}
+
+	for _, menuID := range menus.order {
+		if !reachable[menuID] {
+			hr.Unreachable = append(hr.Unreachable, menuID)
+		}
+	}
+
+	for menuID := range hierarchy {
+		if _, exists := menus.menus[menuID]; !exists {
+			hr.Unrendered = append(hr.Unrendered, menuID)
+		}
+	}
+	sort.Strings(hr.Unrendered)
+}
+
+// normalizeCycle начинает кольцо с наименьшего ID, чтобы одно кольцо не попадало в отчет дважды
+func normalizeCycle(cycle []string) []string {
+	start := 0
+	for i, menuID := range cycle {
+		if menuID < cycle[start] {
+			start = i
+		}
+	}
+
+	normalized := make([]string, 0, len(cycle))
+	normalized = append(normalized, cycle[start:]...)
+	return append(normalized, cycle[:start]...)
+}
+
+// HasErrors сообщает, есть ли в отчете ошибки
+func (hr HierarchyReport) HasErrors() bool {
+	return len(hr.Cycles) > 0 || len(hr.MissingParents) > 0 || len(hr.Orphans) > 0 || len(hr.DanglingButtons) > 0
+}
+
+// Err возвращает ошибку со всеми ошибками отчета или nil, предупреждения не учитываются
+func (hr HierarchyReport) Err() error {
+	if !hr.HasErrors() {
+		return nil
+	}
+	return fmt.Errorf("invalid menu hierarchy:\n  %s", strings.Join(hr.errors(), "\n  "))
+}
+
+// Warnings возвращает предупреждения отчета в виде строк
+func (hr HierarchyReport) Warnings() []string {
+	var warnings []string
+	if len(hr.Unreachable) > 0 {
+		warnings = append(warnings, fmt.Sprintf("unreachable menus: %s", strings.Join(hr.Unreachable, ", ")))
+	}
+	if len(hr.Unrendered) > 0 {
+		warnings = append(warnings, fmt.Sprintf("menus without renderer: %s", strings.Join(hr.Unrendered, ", ")))
+	}
+	return warnings
+}
+
+func (hr HierarchyReport) errors() []string {
+	var problems []string
+	for _, cycle := range hr.Cycles {
+		problems = append(problems, fmt.Sprintf("cycle: %s -> %s", strings.Join(cycle, " -> "), cycle[0]))
+	}
+	for _, link := range hr.MissingParents {
+		problems = append(problems, fmt.Sprintf("menu %q: parent %q does not exist", link.MenuID, link.ParentID))
+	}
+	if len(hr.Orphans) > 0 {
+		problems = append(problems, fmt.Sprintf("menus not connected to %q: %s", hr.Root, strings.Join(hr.Orphans, ", ")))
+	}
+	for _, button := range hr.DanglingButtons {
+		problems = append(problems, fmt.Sprintf("menu %q: button %q points to unknown menu %q", button.MenuID, button.Text, button.Target))
+	}
+	return problems
+}
+
+// String возвращает отчет целиком: ошибки и предупреждения
+func (hr HierarchyReport) String() string {
+	lines := append(hr.errors(), hr.Warnings()...)
+	if len(lines) == 0 {
+		return "menu hierarchy is valid"
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestHierarchyCustomRoot(t *testing.T) {
+	md, err := ParseMenuDefinition("menus.yaml", []byte(`
+version: 1
+root: home
+menus:
+  - id: home
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    parent: home
+    buttons:
+      - [{text: Язык, menu: language}]
+  - id: language
+    parent: settings
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	menus, err := md.Registry()
+	if err != nil {
+		t.Fatalf("registry: %v", err)
+	}
+	hn := NewHierarchicalNavigationFromDefinition(md)
+
+	report := hn.Validate(menus)
+	if err := report.Err(); err != nil {
+		t.Fatalf("validate: %v", err)
+	}
+	if len(report.Unreachable) > 0 {
+		t.Errorf("unreachable = %v", report.Unreachable)
+	}
+
+	if got, want := hn.GetBreadcrumb("language"), []string{"home", "settings", "language"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("breadcrumb = %v, want %v", got, want)
+	}
+	if screen, ok := hn.BackFrom(NavigationScope{}, ""); !ok || screen.MenuID != "home" {
+		t.Errorf("back from old button = %q, %v", screen.MenuID, ok)
+	}
+	if graph := hn.Graph(menus); graph.Root != "home" {
+		t.Errorf("graph root = %q", graph.Root)
+	}
+}
+
+func TestHierarchyOrphans(t *testing.T) {
+	hn := NewHierarchicalNavigationFromDefinition(&MenuDefinition{Root: "home"})
+	hn.ReplaceHierarchy(map[string]string{"settings": "home", "lost": "nowhere"})
+
+	report := hn.Validate(nil)
+	if !reflect.DeepEqual(report.Orphans, []string{"lost"}) {
+		t.Errorf("orphans = %v", report.Orphans)
+	}
+	if err := report.Err(); err == nil {
+		t.Error("orphaned menu passed validation")
+	}
+}
+
+func TestHierarchyParentsFromDefinition(t *testing.T) {
+	md, err := ParseMenuDefinition("menus.yaml", []byte(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Статистика, menu: stats}, {text: Каналы, menu: channels}]
+  - id: stats
+    parent: main
+    buttons:
+      - [{text: По каналам, menu: channel_stats}]
+  - id: channels
+    parent: main
+    buttons:
+      - [{text: Статистика, menu: channel_stats}]
+  - id: channel_stats
+    parents: [stats, channels]
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	hn := NewHierarchicalNavigationFromDefinition(md)
+
+	if got := hn.Parents()["channel_stats"]; !reflect.DeepEqual(got, []string{"stats", "channels"}) {
+		t.Fatalf("parents = %v", got)
+	}
+
+	// Через дополнительного родителя "назад" возвращает туда, откуда пришли
+	from := Screen{MenuID: "channels", Path: []string{"main", "channels"}}
+	screen := NewScreen(from, "channel_stats")
+	btn := hn.BackButton(screen)
+	parent, _, _ := hn.Resolve(NavigationScope{}, btn.Unique)
+	if parent.MenuID != "channels" {
+		t.Errorf("back via extra parent = %q, want channels", parent.MenuID)
+	}
+
+	// Перезагрузка заменяет и дополнительных родителей
+	hn.ReplaceDefinition(&MenuDefinition{Menus: []*Menu{
+		{ID: "main"},
+		{ID: "stats", Parent: "main"},
+		{ID: "channels", Parent: "main"},
+		{ID: "channel_stats", Parent: "stats"},
+	}})
+	if got := hn.Parents()["channel_stats"]; !reflect.DeepEqual(got, []string{"stats"}) {
+		t.Errorf("parents after reload = %v", got)
+	}
+
+	// Путь в старой кнопке устарел - "назад" ведет к основному родителю
+	parent, _, _ = hn.Resolve(NavigationScope{}, btn.Unique)
+	if parent.MenuID != "stats" {
+		t.Errorf("back with stale path = %q, want stats", parent.MenuID)
+	}
+}
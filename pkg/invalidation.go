@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// InvalidationBus - рассылка между репликами бота о том, что стек пользователя изменился
+// Каждая реплика после записи стека публикует событие, остальные удаляют пользователя из кэша
+// и при следующем клике читают свежий стек из хранилища.
+type InvalidationBus interface {
+	// Publish сообщает другим репликам, что стеки пользователей записаны
+	Publish(ctx context.Context, event Invalidation) error
+
+	// Subscribe вызывает handler для каждого события, unsubscribe отменяет подписку
+	Subscribe(handler func(Invalidation)) (unsubscribe func(), err error)
+}
+
+// Invalidation - событие об изменении стеков
+type Invalidation struct {
+	Origin string            // Реплика-отправитель, свои события игнорируются
+	Scopes []NavigationScope // Области, чьи стеки изменились
+	Users  []int64           // Пользователи, чьи данные удалены целиком (EraseUser)
+	All    bool              // Возможно, события пропущены (переподключение) - очистить весь кэш
+}
+
+// MemoryInvalidationBus - шина внутри одного процесса
+// Позволяет проверить несколько менеджеров над общим хранилищем без кластера PostgreSQL
+type MemoryInvalidationBus struct {
+	handlers map[int]func(Invalidation)
+	nextID   int
+	mutex    sync.RWMutex
+}
+
+func NewMemoryInvalidationBus() *MemoryInvalidationBus {
+	return &MemoryInvalidationBus{
+		handlers: make(map[int]func(Invalidation)),
+	}
+}
+
+// Publish синхронно доставляет событие всем подписчикам
+func (mib *MemoryInvalidationBus) Publish(ctx context.Context, event Invalidation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	mib.mutex.RLock()
+	handlers := make([]func(Invalidation), 0, len(mib.handlers))
+	for _, handler := range mib.handlers {
+		handlers = append(handlers, handler)
+	}
+	mib.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+	return nil
+}
+
+// Subscribe добавляет подписчика
+func (mib *MemoryInvalidationBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	mib.mutex.Lock()
+	defer mib.mutex.Unlock()
+
+	id := mib.nextID
+	mib.nextID++
+	mib.handlers[id] = handler
+
+	return func() {
+		mib.mutex.Lock()
+		defer mib.mutex.Unlock()
+
+		delete(mib.handlers, id)
+	}, nil
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// navigationChannel - канал LISTEN/NOTIFY для событий навигации
+const navigationChannel = "navigation_invalidate"
+
+// Полезная нагрузка NOTIFY ограничена 8000 байт, оставляем запас
+const maxNotifyPayload = 7900
+
+// PostgresInvalidationBus - шина между репликами через PostgreSQL LISTEN/NOTIFY
+// Событие кодируется как "origin|user:chat:thread:message,...", удаленный пользователь - просто "user"
+type PostgresInvalidationBus struct {
+	db       *sql.DB
+	listener *pq.Listener
+
+	handlers map[int]func(Invalidation)
+	nextID   int
+	mutex    sync.RWMutex
+
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewPostgresInvalidationBus подключается к каналу navigation_invalidate
+// connStr нужен отдельно от db: LISTEN держит собственное соединение
+func NewPostgresInvalidationBus(db *sql.DB, connStr string) (*PostgresInvalidationBus, error) {
+	listener := pq.NewListener(connStr, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("⚠️  Соединение LISTEN навигации: %v", err)
+		}
+	})
+
+	if err := listener.Listen(navigationChannel); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("listen %s: %v", navigationChannel, err)
+	}
+
+	pib := &PostgresInvalidationBus{
+		db:       db,
+		listener: listener,
+		handlers: make(map[int]func(Invalidation)),
+		done:     make(chan struct{}),
+	}
+
+	pib.wg.Add(1)
+	go pib.run()
+
+	return pib, nil
+}
+
+// Publish отправляет событие через NOTIFY, длинные списки делятся на несколько сообщений
+func (pib *PostgresInvalidationBus) Publish(ctx context.Context, event Invalidation) error {
+	for _, payload := range encodeInvalidation(event) {
+		if _, err := pib.db.ExecContext(ctx, "SELECT pg_notify($1, $2)", navigationChannel, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Subscribe добавляет подписчика
+func (pib *PostgresInvalidationBus) Subscribe(handler func(Invalidation)) (func(), error) {
+	pib.mutex.Lock()
+	defer pib.mutex.Unlock()
+
+	id := pib.nextID
+	pib.nextID++
+	pib.handlers[id] = handler
+
+	return func() {
+		pib.mutex.Lock()
+		defer pib.mutex.Unlock()
+
+		delete(pib.handlers, id)
+	}, nil
+}
+
+// Close останавливает прием событий и закрывает соединение LISTEN
+// Повторные вызовы возвращают результат первого
+func (pib *PostgresInvalidationBus) Close() error {
+	pib.closeOnce.Do(func() {
+		close(pib.done)
+		pib.wg.Wait()
+		pib.closeErr = pib.listener.Close()
+	})
+	return pib.closeErr
+}
+
+// run читает уведомления и раздает их подписчикам
+func (pib *PostgresInvalidationBus) run() {
+	defer pib.wg.Done()
+
+	// Пинг раз в минуту, чтобы быстрее заметить разорванное соединение
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case notification := <-pib.listener.Notify:
+			if notification == nil {
+				// Соединение переподключилось - события за это время потеряны
+				pib.dispatch(Invalidation{All: true})
+				continue
+			}
+
+			event, err := decodeInvalidation(notification.Extra)
+			if err != nil {
+				log.Printf("⚠️  Некорректное событие навигации %q: %v", notification.Extra, err)
+				continue
+			}
+			pib.dispatch(event)
+
+		case <-ticker.C:
+			go pib.listener.Ping()
+
+		case <-pib.done:
+			return
+		}
+	}
+}
+
+func (pib *PostgresInvalidationBus) dispatch(event Invalidation) {
+	pib.mutex.RLock()
+	defer pib.mutex.RUnlock()
+
+	for _, handler := range pib.handlers {
+		handler(event)
+	}
+}
+
+// encodeInvalidation разбивает событие на сообщения не длиннее maxNotifyPayload
+func encodeInvalidation(event Invalidation) []string {
+	if event.All {
+		return []string{event.Origin + "|*"}
+	}
+
+	ids := make([]string, 0, len(event.Scopes)+len(event.Users))
+	for _, scope := range event.Scopes {
+		ids = append(ids, encodeScope(scope))
+	}
+	for _, userID := range event.Users {
+		ids = append(ids, strconv.FormatInt(userID, 10))
+	}
+
+	var payloads []string
+	var chunk []string
+	size := len(event.Origin) + 1
+
+	for _, id := range ids {
+		if len(chunk) > 0 && size+len(id)+1 > maxNotifyPayload {
+			payloads = append(payloads, event.Origin+"|"+strings.Join(chunk, ","))
+			chunk = chunk[:0]
+			size = len(event.Origin) + 1
+		}
+		chunk = append(chunk, id)
+		size += len(id) + 1
+	}
+	if len(chunk) > 0 {
+		payloads = append(payloads, event.Origin+"|"+strings.Join(chunk, ","))
+	}
+	return payloads
+}
+
+func decodeInvalidation(payload string) (Invalidation, error) {
+	origin, list, found := strings.Cut(payload, "|")
+	if !found {
+		return Invalidation{}, fmt.Errorf("missing origin separator")
+	}
+
+	event := Invalidation{Origin: origin}
+	if list == "*" {
+		event.All = true
+		return event, nil
+	}
+
+	for _, id := range strings.Split(list, ",") {
+		if !strings.Contains(id, ":") {
+			userID, err := strconv.ParseInt(id, 10, 64)
+			if err != nil {
+				return Invalidation{}, fmt.Errorf("bad user id %q", id)
+			}
+			event.Users = append(event.Users, userID)
+			continue
+		}
+
+		scope, err := decodeScope(id)
+		if err != nil {
+			return Invalidation{}, err
+		}
+		event.Scopes = append(event.Scopes, scope)
+	}
+	return event, nil
+}
+
+func encodeScope(scope NavigationScope) string {
+	return strconv.FormatInt(scope.UserID, 10) + ":" +
+		strconv.FormatInt(scope.ChatID, 10) + ":" +
+		strconv.Itoa(scope.ThreadID) + ":" +
+		strconv.Itoa(scope.MessageID)
+}
+
+// decodeScope принимает и формат без сообщения от реплик старой версии
+func decodeScope(id string) (NavigationScope, error) {
+	parts := strings.Split(id, ":")
+	if len(parts) != 3 && len(parts) != 4 {
+		return NavigationScope{}, fmt.Errorf("bad scope %q", id)
+	}
+
+	userID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return NavigationScope{}, fmt.Errorf("bad user id in scope %q", id)
+	}
+	chatID, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return NavigationScope{}, fmt.Errorf("bad chat id in scope %q", id)
+	}
+	threadID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return NavigationScope{}, fmt.Errorf("bad thread id in scope %q", id)
+	}
+
+	scope := NavigationScope{UserID: userID, ChatID: chatID, ThreadID: threadID}
+	if len(parts) == 4 {
+		if scope.MessageID, err = strconv.Atoi(parts[3]); err != nil {
+			return NavigationScope{}, fmt.Errorf("bad message id in scope %q", id)
+		}
+	}
+	return scope, nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// waitSaved записывает очередь менеджера и дожидается пачки, которую мог забрать фоновый процесс
+func waitSaved(pnm *PersistentNavigationManager) {
+	pnm.writes.flushBatch(context.Background())
+	pnm.writes.flushing.Lock()
+	pnm.writes.flushing.Unlock()
+}
+
+func TestMemoryInvalidationBusEvictsOtherReplica(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryNavigationStore()
+	bus := NewMemoryInvalidationBus()
+
+	first := NewPersistentNavigationManagerWithStore(store)
+	second := NewPersistentNavigationManagerWithStore(store)
+	for _, pnm := range []*PersistentNavigationManager{first, second} {
+		pnm := pnm
+		t.Cleanup(func() { pnm.Close(context.Background()) })
+		if err := pnm.SetInvalidationBus(bus); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	scope := UserScope(1)
+	for _, menuID := range []string{"main", "settings"} {
+		if err := first.PushMenu(ctx, scope, menuID); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitSaved(first)
+
+	// Вторая реплика читает стек из хранилища и кэширует его
+	record, err := second.getStackFromCacheOrDB(ctx, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMenus(record.Stack, []string{"main", "settings"}) {
+		t.Fatalf("second replica stack = %v", record.Stack)
+	}
+	if _, cached := second.cache.Get(scope); !cached {
+		t.Fatal("second replica did not cache the stack")
+	}
+
+	// Клик попал на первую реплику - после записи вторая должна забыть свой стек
+	if err := first.PushMenu(ctx, scope, "privacy"); err != nil {
+		t.Fatal(err)
+	}
+	waitSaved(first)
+
+	if _, cached := second.cache.Get(scope); cached {
+		t.Error("second replica kept a stale stack after the first one saved")
+	}
+	if _, cached := first.cache.Get(scope); !cached {
+		t.Error("first replica evicted its own stack")
+	}
+
+	record, err = second.getStackFromCacheOrDB(ctx, scope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !sameMenus(record.Stack, []string{"main", "settings", "privacy"}) {
+		t.Errorf("second replica stack after invalidation = %v", record.Stack)
+	}
+
+	stats, err := second.GetNavigationStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received := stats["invalidations_received"].(int64); received == 0 {
+		t.Error("second replica counted no invalidations")
+	}
+}
+
+func TestPostgresInvalidationBusCloseTwice(t *testing.T) {
+	// Сервер не нужен: слушатель переподключается в фоне, пока его не закроют
+	pib := &PostgresInvalidationBus{
+		listener: pq.NewListener("host=127.0.0.1 port=1 sslmode=disable connect_timeout=1", time.Second, time.Second, nil),
+		handlers: make(map[int]func(Invalidation)),
+		done:     make(chan struct{}),
+	}
+	pib.wg.Add(1)
+	go pib.run()
+
+	first := pib.Close()
+	if second := pib.Close(); second != first {
+		t.Errorf("second Close = %v, want %v", second, first)
+	}
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+// MenuDefinition - дерево меню, загруженное из YAML/JSON файла
+// Структура, заголовки, тексты и кнопки редактируются без изменения Go кода
+type MenuDefinition struct {
+	Version int
+	Root    string
+	Menus   []*Menu
+}
+
+// menuDefinitionVersion - поддерживаемая версия формата файла
+const menuDefinitionVersion = 1
+
+// DefinitionError - ошибка в файле описания меню с указанием позиции
+type DefinitionError struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e DefinitionError) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// DefinitionErrors - все ошибки, найденные при разборе файла
+type DefinitionErrors []DefinitionError
+
+func (e DefinitionErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// LoadMenuDefinition читает и валидирует файл описания меню
+// JSON - подмножество YAML, поэтому оба формата разбираются одним парсером
+func LoadMenuDefinition(path string) (*MenuDefinition, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseMenuDefinition(path, data)
+}
+
+// ParseMenuDefinition разбирает описание меню, name используется в сообщениях об ошибках
+func ParseMenuDefinition(name string, data []byte) (*MenuDefinition, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+
+	p := &definitionParser{file: name, menuNodes: make(map[string]*yaml.Node)}
+	md := p.parseDocument(&doc)
+
+	if len(p.errs) > 0 {
+		return nil, p.errs
+	}
+	return md, nil
+}
+
+// Registry строит реестр меню по описанию
+func (md *MenuDefinition) Registry() (*MenuRegistry, error) {
+	registry := NewMenuRegistry(md.Root)
+	if err := registry.Register(md.Menus...); err != nil {
+		return nil, err
+	}
+	if err := registry.Validate(); err != nil {
+		return nil, err
+	}
+	return registry, nil
+}
+
+// Hierarchy возвращает карту menu_id -> parent_id для HierarchicalNavigation
+func (md *MenuDefinition) Hierarchy() map[string]string {
+	hierarchy := make(map[string]string)
+	for _, menu := range md.Menus {
+		if menu.Parent != "" {
+			hierarchy[menu.ID] = menu.Parent
+		}
+	}
+	return hierarchy
+}
+
+// Parents возвращает карту menu_id -> [основной родитель, дополнительные...]
+func (md *MenuDefinition) Parents() map[string][]string {
+	parents := make(map[string][]string)
+	for _, menu := range md.Menus {
+		if menu.Parent != "" {
+			parents[menu.ID] = append([]string{menu.Parent}, menu.Extra...)
+		}
+	}
+	return parents
+}
+
+// definitionParser обходит YAML дерево и копит ошибки с номерами строк
+type definitionParser struct {
+	file      string
+	errs      DefinitionErrors
+	menuNodes map[string]*yaml.Node // id -> узел меню, для ссылок с позицией
+	refs      []menuReference       // Ссылки на меню, проверяются после разбора всех меню
+}
+
+// menuReference - ссылка на меню из поля parent(s) или кнопки
+type menuReference struct {
+	node *yaml.Node
+	what string
+}
+
+func (p *definitionParser) fail(node *yaml.Node, format string, args ...interface{}) {
+	p.errs = append(p.errs, DefinitionError{
+		File:   p.file,
+		Line:   node.Line,
+		Column: node.Column,
+		Msg:    fmt.Sprintf(format, args...),
+	})
+}
+
+// mapping проверяет, что узел - объект с известными ключами, и возвращает его поля
+func (p *definitionParser) mapping(node *yaml.Node, what string, allowed ...string) map[string]*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		p.fail(node, "%s must be an object", what)
+		return nil
+	}
+
+	fields := make(map[string]*yaml.Node)
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+
+		known := false
+		for _, name := range allowed {
+			if key.Value == name {
+				known = true
+				break
+			}
+		}
+
+		switch {
+		case !known:
+			p.fail(key, "unknown key %q in %s", key.Value, what)
+		case fields[key.Value] != nil:
+			p.fail(key, "duplicate key %q in %s", key.Value, what)
+		default:
+			fields[key.Value] = value
+		}
+	}
+	return fields
+}
+
+// str возвращает строковое значение скаляра
+func (p *definitionParser) str(node *yaml.Node, what string) string {
+	if node.Kind != yaml.ScalarNode || node.Tag != "!!str" {
+		p.fail(node, "%s must be a string", what)
+		return ""
+	}
+	return node.Value
+}
+
+func (p *definitionParser) parseDocument(doc *yaml.Node) *MenuDefinition {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 {
+		p.fail(doc, "empty menu definition")
+		return nil
+	}
+
+	fields := p.mapping(doc.Content[0], "definition", "version", "root", "menus")
+	if fields == nil {
+		return nil
+	}
+
+	md := &MenuDefinition{Root: "main"}
+
+	if node := fields["version"]; node == nil {
+		p.fail(doc.Content[0], "missing required key \"version\"")
+	} else if version, err := strconv.Atoi(node.Value); err != nil || node.Tag != "!!int" {
+		p.fail(node, "version must be an integer")
+	} else if version != menuDefinitionVersion {
+		p.fail(node, "unsupported version %d (expected %d)", version, menuDefinitionVersion)
+	} else {
+		md.Version = version
+	}
+
+	rootNode := fields["root"]
+	if rootNode != nil {
+		md.Root = p.str(rootNode, "root")
+	}
+
+	menusNode := fields["menus"]
+	if menusNode == nil {
+		p.fail(doc.Content[0], "missing required key \"menus\"")
+		return md
+	}
+	if menusNode.Kind != yaml.SequenceNode {
+		p.fail(menusNode, "menus must be a list")
+		return md
+	}
+
+	for i, node := range menusNode.Content {
+		if menu := p.parseMenu(i, node); menu != nil {
+			md.Menus = append(md.Menus, menu)
+		}
+	}
+
+	p.checkReferences(md, menusNode, rootNode)
+	return md
+}
+
+// parseMenu разбирает меню с порядковым номером index в списке menus
+func (p *definitionParser) parseMenu(index int, node *yaml.Node) *Menu {
+	fields := p.mapping(node, "menu", "id", "title", "parent", "parents", "text", "buttons")
+	if fields == nil {
+		return nil
+	}
+
+	idNode := fields["id"]
+	if idNode == nil {
+		p.fail(node, "menu #%d is missing required key \"id\"", index+1)
+		return nil
+	}
+
+	menu := &Menu{ID: p.str(idNode, "menu id")}
+	if menu.ID == "" {
+		if idNode.Kind == yaml.ScalarNode && idNode.Tag == "!!str" {
+			p.fail(idNode, "menu #%d has empty id", index+1)
+		}
+		return nil
+	}
+	if first, exists := p.menuNodes[menu.ID]; exists {
+		p.fail(idNode, "duplicate menu id %q (first defined at line %d)", menu.ID, first.Line)
+		return nil
+	}
+	p.menuNodes[menu.ID] = idNode
+
+	if titleNode := fields["title"]; titleNode != nil {
+		menu.Title = p.str(titleNode, "title")
+	}
+	if parentNode := fields["parent"]; parentNode != nil {
+		menu.Parent = p.str(parentNode, "parent")
+		p.refs = append(p.refs, menuReference{node: parentNode, what: "parent"})
+	}
+	if parentsNode := fields["parents"]; parentsNode != nil {
+		if fields["parent"] != nil {
+			p.fail(parentsNode, "menu %q has both \"parent\" and \"parents\"", menu.ID)
+		} else {
+			p.parseParents(menu, parentsNode)
+		}
+	}
+	if textNode := fields["text"]; textNode != nil {
+		menu.Text = p.parseText(menu, textNode)
+	}
+	if buttonsNode := fields["buttons"]; buttonsNode != nil {
+		menu.Buttons = p.parseButtons(buttonsNode)
+	}
+
+	return menu
+}
+
+// parseParents разбирает parents: [основной, дополнительные...]
+// Первый родитель - основной, к нему ведет "назад", если путь пользователя неизвестен
+func (p *definitionParser) parseParents(menu *Menu, node *yaml.Node) {
+	if node.Kind != yaml.SequenceNode || len(node.Content) == 0 {
+		p.fail(node, "parents must be a non-empty list")
+		return
+	}
+
+	seen := make(map[string]bool, len(node.Content))
+	for i, parentNode := range node.Content {
+		parentID := p.str(parentNode, "parent")
+		if parentID == "" {
+			continue
+		}
+		if seen[parentID] {
+			p.fail(parentNode, "duplicate parent %q", parentID)
+			continue
+		}
+		seen[parentID] = true
+		p.refs = append(p.refs, menuReference{node: parentNode, what: "parent"})
+
+		if i == 0 {
+			menu.Parent = parentID
+		} else {
+			menu.Extra = append(menu.Extra, parentID)
+		}
+	}
+}
+
+// parseText компилирует текст экрана как text/template с данными Screen
+func (p *definitionParser) parseText(menu *Menu, node *yaml.Node) func(Screen) string {
+	text := p.str(node, "text")
+
+	tmpl, err := template.New(menu.ID).Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+	if err != nil {
+		p.fail(node, "invalid text template: %v", err)
+		return nil
+	}
+
+	return func(screen Screen) string {
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, screen); err != nil {
+			log.Printf("❌ Ошибка рендера меню %q: %v", menu.ID, err)
+			return "<b>" + menu.Title + "</b>"
+		}
+		return buf.String()
+	}
+}
+
+func (p *definitionParser) parseButtons(node *yaml.Node) [][]MenuItem {
+	if node.Kind != yaml.SequenceNode {
+		p.fail(node, "buttons must be a list of rows")
+		return nil
+	}
+
+	rows := make([][]MenuItem, 0, len(node.Content))
+	for _, rowNode := range node.Content {
+		if rowNode.Kind != yaml.SequenceNode {
+			p.fail(rowNode, "button row must be a list")
+			continue
+		}
+
+		row := make([]MenuItem, 0, len(rowNode.Content))
+		for _, btnNode := range rowNode.Content {
+			fields := p.mapping(btnNode, "button", "text", "menu", "data")
+			if fields == nil {
+				continue
+			}
+
+			var item MenuItem
+			if textNode := fields["text"]; textNode != nil {
+				item.Text = p.str(textNode, "button text")
+			} else {
+				p.fail(btnNode, "button is missing required key \"text\"")
+			}
+
+			menuNode, dataNode := fields["menu"], fields["data"]
+			switch {
+			case menuNode != nil && dataNode != nil:
+				p.fail(btnNode, "button must have either \"menu\" or \"data\", not both")
+			case menuNode != nil:
+				item.Target = p.str(menuNode, "button menu")
+				p.refs = append(p.refs, menuReference{node: menuNode, what: "button menu"})
+			case dataNode != nil:
+				item.Data = p.str(dataNode, "button data")
+			default:
+				p.fail(btnNode, "button must have \"menu\" or \"data\"")
+			}
+
+			row = append(row, item)
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// checkReferences проверяет ссылки на меню: корень, родителей и кнопки
+func (p *definitionParser) checkReferences(md *MenuDefinition, menusNode, rootNode *yaml.Node) {
+	if _, exists := p.menuNodes[md.Root]; !exists {
+		node := rootNode
+		if node == nil {
+			node = menusNode
+		}
+		p.fail(node, "root menu %q is not defined", md.Root)
+	}
+
+	for _, ref := range p.refs {
+		if _, exists := p.menuNodes[ref.node.Value]; !exists {
+			p.fail(ref.node, "%s %q is not defined", ref.what, ref.node.Value)
+		}
+	}
+}
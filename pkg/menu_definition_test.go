@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestParseMenuDefinition(t *testing.T) {
+	md, err := ParseMenuDefinition("menus.yaml", []byte(`
+version: 1
+root: home
+menus:
+  - id: home
+    title: Главная
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    title: Настройки
+    parent: home
+    buttons:
+      - [{text: Сброс, data: reset}]
+`))
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+
+	if md.Root != "home" || len(md.Menus) != 2 {
+		t.Fatalf("root %q, menus %d", md.Root, len(md.Menus))
+	}
+	if got := md.Hierarchy(); len(got) != 1 || got["settings"] != "home" {
+		t.Errorf("hierarchy = %v", got)
+	}
+	if item := md.Menus[0].Buttons[0][0]; item.Target != "settings" {
+		t.Errorf("button target = %q", item.Target)
+	}
+	if _, err := md.Registry(); err != nil {
+		t.Errorf("registry: %v", err)
+	}
+}
+
+func TestParseMenuDefinitionErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{
+			name: "empty id",
+			data: "version: 1\nmenus:\n  - id: main\n  - id: \"\"\n",
+			want: `menus.yaml:4:9: menu #2 has empty id`,
+		},
+		{
+			name: "missing id",
+			data: "version: 1\nmenus:\n  - id: main\n  - title: x\n",
+			want: `menus.yaml:4:5: menu #2 is missing required key "id"`,
+		},
+		{
+			name: "unknown parent",
+			data: "version: 1\nmenus:\n  - id: main\n    parent: nowhere\n",
+			want: `menus.yaml:4:13: parent "nowhere" is not defined`,
+		},
+		{
+			name: "parent and parents",
+			data: "version: 1\nmenus:\n  - id: main\n  - id: a\n    parent: main\n    parents: [main]\n",
+			want: `menus.yaml:6:14: menu "a" has both "parent" and "parents"`,
+		},
+		{
+			name: "unknown extra parent",
+			data: "version: 1\nmenus:\n  - id: main\n  - id: a\n    parents: [main, nowhere]\n",
+			want: `menus.yaml:5:21: parent "nowhere" is not defined`,
+		},
+		{
+			name: "unknown root",
+			data: "version: 1\nroot: home\nmenus:\n  - id: main\n",
+			want: `menus.yaml:2:7: root menu "home" is not defined`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseMenuDefinition("menus.yaml", []byte(tt.data))
+
+			var errs DefinitionErrors
+			if !errors.As(err, &errs) {
+				t.Fatalf("error = %v, want DefinitionErrors", err)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want %q", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// MenuGraph - структура меню для диаграмм: иерархия и кнопки реестра в одном графе
+// Строится через Graph, выводится в Graphviz (WriteDOT) или Mermaid (WriteMermaid).
+type MenuGraph struct {
+	Root  string
+	Nodes []MenuGraphNode
+	Edges []MenuGraphEdge
+}
+
+// MenuGraphNode - меню на диаграмме
+type MenuGraphNode struct {
+	ID          string
+	Title       string
+	Unreachable bool // Нет пути от корня по кнопкам реестра или по родителям иерархии
+	Unrendered  bool // Меню нет в реестре - экран некому показать
+}
+
+// MenuGraphEdge - переход между меню, связь иерархии и кнопки реестра объединены
+type MenuGraphEdge struct {
+	From    string
+	To      string
+	Parent  bool     // From - основной родитель To
+	Extra   bool     // From - дополнительный родитель To (AddParent)
+	Buttons []string // Тексты кнопок меню From, ведущих в To
+}
+
+// Graph строит граф меню по иерархии и кнопкам реестра menus
+// Недостижимые и неотрисовываемые меню берутся из Validate. Без реестра (nil) на графе только иерархия.
+func (hn *HierarchicalNavigation) Graph(menus *MenuRegistry) MenuGraph {
+	report := hn.Validate(menus)
+	graph := MenuGraph{Root: report.Root}
+
+	nodes := make(map[string]*MenuGraphNode)
+	node := func(menuID string) *MenuGraphNode {
+		if n, exists := nodes[menuID]; exists {
+			return n
+		}
+		n := &MenuGraphNode{ID: menuID}
+		nodes[menuID] = n
+		return n
+	}
+
+	edges := make(map[[2]string]*MenuGraphEdge)
+	edge := func(from, to string) *MenuGraphEdge {
+		key := [2]string{from, to}
+		if e, exists := edges[key]; exists {
+			return e
+		}
+		e := &MenuGraphEdge{From: from, To: to}
+		edges[key] = e
+		return e
+	}
+
+	node(graph.Root)
+	for menuID, parents := range hn.Parents() {
+		node(menuID)
+		for i, parentID := range parents {
+			if parentID == "" || menuID == graph.Root {
+				continue
+			}
+			node(parentID)
+			if i == 0 {
+				edge(parentID, menuID).Parent = true
+			} else {
+				edge(parentID, menuID).Extra = true
+			}
+		}
+	}
+
+	if menus != nil {
+		node(menus.root)
+		for _, menuID := range menus.order {
+			menu := menus.menus[menuID]
+			node(menuID).Title = menu.Title
+			for _, row := range menu.Buttons {
+				for _, item := range row {
+					if item.Target == "" {
+						continue
+					}
+					node(item.Target)
+					e := edge(menuID, item.Target)
+					e.Buttons = append(e.Buttons, item.Text)
+				}
+			}
+		}
+
+		// Кнопки в незарегистрированные меню ведут на экраны, которые некому показать
+		for menuID, n := range nodes {
+			if _, exists := menus.menus[menuID]; !exists {
+				n.Unrendered = true
+			}
+		}
+	}
+
+	for _, menuID := range report.Unrendered {
+		node(menuID).Unrendered = true
+	}
+	for _, menuID := range append(report.Unreachable, report.Orphans...) {
+		node(menuID).Unreachable = true
+	}
+
+	for _, n := range nodes {
+		graph.Nodes = append(graph.Nodes, *n)
+	}
+	sort.Slice(graph.Nodes, func(i, j int) bool {
+		return graph.Nodes[i].ID < graph.Nodes[j].ID
+	})
+
+	for _, e := range edges {
+		graph.Edges = append(graph.Edges, *e)
+	}
+	sort.Slice(graph.Edges, func(i, j int) bool {
+		a, b := graph.Edges[i], graph.Edges[j]
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+
+	return graph
+}
+
+// WriteDOT выводит граф в формате Graphviz: dot -Tsvg menus.dot -o menus.svg
+// Недостижимые меню - красные, неотрисовываемые - пунктирные серые,
+// дополнительные родители - пунктирные стрелки, кнопки вне иерархии - синие.
+func (g MenuGraph) WriteDOT(w io.Writer) error {
+	var b strings.Builder
+
+	b.WriteString("digraph menus {\n")
+	b.WriteString("\trankdir=LR;\n")
+	b.WriteString("\tnode [shape=box, style=\"rounded,filled\", fillcolor=white, fontname=\"Helvetica\"];\n")
+	b.WriteString("\tedge [fontname=\"Helvetica\", fontsize=10];\n\n")
+
+	for _, n := range g.Nodes {
+		style := "rounded,filled"
+		if n.Unrendered {
+			style += ",dashed"
+		}
+		attrs := []string{"label=" + dotQuote(g.label(n)), "style=" + dotQuote(style)}
+		if n.Unrendered {
+			attrs = append(attrs, "fontcolor=gray40")
+		}
+		switch {
+		case n.Unreachable:
+			attrs = append(attrs, `fillcolor="#ffd6d6"`, `color="#cc0000"`)
+		case n.Unrendered:
+			attrs = append(attrs, `fillcolor="#eeeeee"`, "color=gray40")
+		}
+		if n.ID == g.Root {
+			attrs = append(attrs, "penwidth=2")
+		}
+
+		fmt.Fprintf(&b, "\t%s [%s];\n", dotQuote(n.ID), strings.Join(attrs, ", "))
+	}
+	b.WriteString("\n")
+
+	for _, e := range g.Edges {
+		var attrs []string
+		if len(e.Buttons) > 0 {
+			attrs = append(attrs, "label="+dotQuote(strings.Join(e.Buttons, "\n")))
+		}
+		switch {
+		case e.Extra:
+			attrs = append(attrs, "style=dashed")
+		case !e.Parent:
+			attrs = append(attrs, "color=blue", "fontcolor=blue")
+		}
+
+		fmt.Fprintf(&b, "\t%s -> %s", dotQuote(e.From), dotQuote(e.To))
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, " [%s]", strings.Join(attrs, ", "))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// WriteMermaid выводит граф в формате Mermaid (flowchart), его понимают GitHub и GitLab
+// Стрелки: --> иерархия, -.-> дополнительный родитель, ==> кнопка вне иерархии.
+func (g MenuGraph) WriteMermaid(w io.Writer) error {
+	var b strings.Builder
+
+	// ID меню могут содержать символы, недопустимые в Mermaid, поэтому узлы нумеруются
+	ids := make(map[string]string, len(g.Nodes))
+	var unreachable, unrendered []string
+
+	b.WriteString("flowchart LR\n")
+	for i, n := range g.Nodes {
+		id := fmt.Sprintf("m%d", i)
+		ids[n.ID] = id
+		fmt.Fprintf(&b, "\t%s[\"%s\"]\n", id, mermaidEscape(g.label(n)))
+
+		if n.Unreachable {
+			unreachable = append(unreachable, id)
+		}
+		if n.Unrendered {
+			unrendered = append(unrendered, id)
+		}
+	}
+
+	for _, e := range g.Edges {
+		arrow := "-->"
+		switch {
+		case e.Extra:
+			arrow = "-.->"
+		case !e.Parent:
+			arrow = "==>"
+		}
+		if len(e.Buttons) > 0 {
+			arrow += "|\"" + mermaidEscape(strings.Join(e.Buttons, ", ")) + "\"|"
+		}
+		fmt.Fprintf(&b, "\t%s %s %s\n", ids[e.From], arrow, ids[e.To])
+	}
+
+	// Стиль недостижимых объявлен последним, чтобы красный цвет перекрывал серый
+	b.WriteString("\tclassDef unrendered fill:#eeeeee,stroke:#666666,stroke-dasharray:5 5,color:#666666\n")
+	b.WriteString("\tclassDef unreachable fill:#ffd6d6,stroke:#cc0000\n")
+	if root, exists := ids[g.Root]; exists {
+		fmt.Fprintf(&b, "\tstyle %s stroke-width:3px\n", root)
+	}
+	if len(unrendered) > 0 {
+		fmt.Fprintf(&b, "\tclass %s unrendered\n", strings.Join(unrendered, ","))
+	}
+	if len(unreachable) > 0 {
+		fmt.Fprintf(&b, "\tclass %s unreachable\n", strings.Join(unreachable, ","))
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// label - подпись узла: ID меню и заголовок из реестра
+func (g MenuGraph) label(n MenuGraphNode) string {
+	if n.Title == "" {
+		return n.ID
+	}
+	return n.ID + "\n" + n.Title
+}
+
+// dotQuote экранирует строку для Graphviz
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	return `"` + strings.ReplaceAll(s, "\n", `\n`) + `"`
+}
+
+// mermaidEscape экранирует строку для подписи Mermaid в кавычках
+func mermaidEscape(s string) string {
+	s = strings.ReplaceAll(s, `"`, "#quot;")
+	return strings.ReplaceAll(s, "\n", "<br/>")
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+// testMenuGraph - граф со всеми видами узлов и переходов:
+// lost недостижимо (родитель nowhere не существует), about и nowhere некому показать,
+// settings - дополнительный родитель help, кнопки help -> main и settings -> about вне иерархии.
+func testMenuGraph(t *testing.T) MenuGraph {
+	t.Helper()
+
+	hn := NewHierarchicalNavigationFromDefinition(&MenuDefinition{Root: "main"})
+	hn.ReplaceHierarchy(map[string]string{"settings": "main", "help": "main", "lost": "nowhere"})
+	hn.AddParent("help", "settings")
+
+	menus := NewMenuRegistry("main")
+	if err := menus.Register(
+		&Menu{ID: "main", Title: "Главное", Buttons: [][]MenuItem{{{Text: "Настройки", Target: "settings"}, {Text: "Помощь", Target: "help"}}}},
+		&Menu{ID: "settings", Title: "Настройки", Buttons: [][]MenuItem{{{Text: "Помощь", Target: "help"}, {Text: "О боте", Target: "about"}}}},
+		&Menu{ID: "help", Title: "Помощь", Buttons: [][]MenuItem{{{Text: "В начало", Target: "main"}}}},
+		&Menu{ID: "lost", Title: "Архив"},
+	); err != nil {
+		t.Fatal(err)
+	}
+	return hn.Graph(menus)
+}
+
+const goldenDOT = `digraph menus {
+	rankdir=LR;
+	node [shape=box, style="rounded,filled", fillcolor=white, fontname="Helvetica"];
+	edge [fontname="Helvetica", fontsize=10];
+
+	"about" [label="about", style="rounded,filled,dashed", fontcolor=gray40, fillcolor="#eeeeee", color=gray40];
+	"help" [label="help\nПомощь", style="rounded,filled"];
+	"lost" [label="lost\nАрхив", style="rounded,filled", fillcolor="#ffd6d6", color="#cc0000"];
+	"main" [label="main\nГлавное", style="rounded,filled", penwidth=2];
+	"nowhere" [label="nowhere", style="rounded,filled,dashed", fontcolor=gray40, fillcolor="#eeeeee", color=gray40];
+	"settings" [label="settings\nНастройки", style="rounded,filled"];
+
+	"help" -> "main" [label="В начало", color=blue, fontcolor=blue];
+	"main" -> "help" [label="Помощь"];
+	"main" -> "settings" [label="Настройки"];
+	"nowhere" -> "lost";
+	"settings" -> "about" [label="О боте", color=blue, fontcolor=blue];
+	"settings" -> "help" [label="Помощь", style=dashed];
+}
+`
+
+const goldenMermaid = `flowchart LR
+	m0["about"]
+	m1["help<br/>Помощь"]
+	m2["lost<br/>Архив"]
+	m3["main<br/>Главное"]
+	m4["nowhere"]
+	m5["settings<br/>Настройки"]
+	m1 ==>|"В начало"| m3
+	m3 -->|"Помощь"| m1
+	m3 -->|"Настройки"| m5
+	m4 --> m2
+	m5 ==>|"О боте"| m0
+	m5 -.->|"Помощь"| m1
+	classDef unrendered fill:#eeeeee,stroke:#666666,stroke-dasharray:5 5,color:#666666
+	classDef unreachable fill:#ffd6d6,stroke:#cc0000
+	style m3 stroke-width:3px
+	class m0,m4 unrendered
+	class m2 unreachable
+`
+
+func TestMenuGraphWriteDOT(t *testing.T) {
+	var b strings.Builder
+	if err := testMenuGraph(t).WriteDOT(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != goldenDOT {
+		t.Errorf("DOT output differs from golden:\n%s", b.String())
+	}
+}
+
+func TestMenuGraphWriteMermaid(t *testing.T) {
+	var b strings.Builder
+	if err := testMenuGraph(t).WriteMermaid(&b); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != goldenMermaid {
+		t.Errorf("Mermaid output differs from golden:\n%s", b.String())
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Menu - декларативное описание одного экрана меню
+type Menu struct {
+	ID      string
+	Title   string
+	Parent  string              // Родитель для стратегий без собственной иерархии
+	Extra   []string            // Дополнительные родители для HierarchicalNavigation (см. AddParent)
+	Text    func(Screen) string // Рендер текста экрана (по умолчанию - заголовок)
+	Buttons [][]MenuItem        // Ряды кнопок
+}
+
+// MenuItem - кнопка на экране меню
+// Ведет либо в другое меню (Target), либо отправляет произвольный callback (Data)
+type MenuItem struct {
+	Text   string
+	Target string
+	Data   string
+}
+
+// StaticText возвращает рендер, который всегда выдает один и тот же текст
+func StaticText(text string) func(Screen) string {
+	return func(Screen) string {
+		return text
+	}
+}
+
+// MenuRegistry - реестр меню, заменяющий switch в showMenu
+// Каждое меню регистрируется один раз, переходы диспетчеризуются автоматически
+type MenuRegistry struct {
+	root  string
+	menus map[string]*Menu
+	order []string // Порядок регистрации для предсказуемых отчетов
+
+	// Предыдущая версия реестра после горячей перезагрузки
+	// Нужна, чтобы кнопки из старых сообщений продолжали открываться
+	previous *MenuRegistry
+}
+
+// MenuSource - источник актуального реестра меню
+// Реестр может подменяться на лету (см. MenuReloader)
+type MenuSource interface {
+	Registry() *MenuRegistry
+
+	// View вызывает fn с текущим реестром, не давая подменить его до возврата
+	// Обработчики, которые читают и реестр, и иерархию навигации, работают через View
+	View(fn func(*MenuRegistry) error) error
+}
+
+func NewMenuRegistry(root string) *MenuRegistry {
+	return &MenuRegistry{
+		root:  root,
+		menus: make(map[string]*Menu),
+	}
+}
+
+// Register регистрирует меню, повторная регистрация ID - ошибка
+func (mr *MenuRegistry) Register(menus ...*Menu) error {
+	for _, menu := range menus {
+		if menu.ID == "" {
+			return fmt.Errorf("menu with empty id")
+		}
+		if _, exists := mr.menus[menu.ID]; exists {
+			return fmt.Errorf("menu %q is already registered", menu.ID)
+		}
+		mr.menus[menu.ID] = menu
+		mr.order = append(mr.order, menu.ID)
+	}
+	return nil
+}
+
+// Registry реализует MenuSource: статичный реестр возвращает сам себя
+func (mr *MenuRegistry) Registry() *MenuRegistry {
+	return mr
+}
+
+// View реализует MenuSource: статичный реестр не подменяется
+func (mr *MenuRegistry) View(fn func(*MenuRegistry) error) error {
+	return fn(mr)
+}
+
+// Get возвращает меню по ID, при отсутствии ищет в предыдущей версии реестра
+func (mr *MenuRegistry) Get(menuID string) (*Menu, bool) {
+	if menu, exists := mr.menus[menuID]; exists {
+		return menu, true
+	}
+	if mr.previous != nil {
+		return mr.previous.Get(menuID)
+	}
+	return nil, false
+}
+
+// Root возвращает ID корневого меню
+func (mr *MenuRegistry) Root() string {
+	return mr.root
+}
+
+// Validate проверяет, что все кнопки и родители ссылаются на зарегистрированные меню
+// Вызывается при старте бота, чтобы битые ссылки не доживали до продакшена
+func (mr *MenuRegistry) Validate() error {
+	var problems []string
+
+	if _, exists := mr.menus[mr.root]; !exists {
+		problems = append(problems, fmt.Sprintf("root menu %q is not registered", mr.root))
+	}
+
+	for _, id := range mr.order {
+		menu := mr.menus[id]
+
+		if menu.Parent != "" {
+			if _, exists := mr.menus[menu.Parent]; !exists {
+				problems = append(problems, fmt.Sprintf("menu %q: parent %q is not registered", id, menu.Parent))
+			}
+		}
+
+		for _, row := range menu.Buttons {
+			for _, item := range row {
+				if item.Target == "" {
+					continue
+				}
+				if _, exists := mr.menus[item.Target]; !exists {
+					problems = append(problems, fmt.Sprintf("menu %q: button %q points to unregistered menu %q", id, item.Text, item.Target))
+				}
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid menu registry:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// pathTo строит путь от корня до меню по полям Parent
+func (mr *MenuRegistry) pathTo(menuID string) []string {
+	var path []string
+	seen := make(map[string]bool)
+
+	for current := menuID; current != "" && !seen[current]; {
+		seen[current] = true
+		path = append([]string{current}, path...)
+
+		menu, exists := mr.Get(current)
+		if !exists {
+			break
+		}
+		current = menu.Parent
+	}
+
+	return path
+}
+
+// Render собирает текст и клавиатуру экрана с помощью стратегии навигации
+func (mr *MenuRegistry) Render(nav Navigator, screen Screen) (string, *tele.ReplyMarkup, error) {
+	menu, exists := mr.Get(screen.MenuID)
+	if !exists {
+		return "", nil, fmt.Errorf("menu %q is not registered", screen.MenuID)
+	}
+
+	selector := &tele.ReplyMarkup{}
+	rows := make([]tele.Row, 0, len(menu.Buttons))
+
+	for _, items := range menu.Buttons {
+		row := make(tele.Row, 0, len(items))
+		for _, item := range items {
+			if item.Target != "" {
+				row = append(row, *nav.MenuButton(screen, item.Text, item.Target))
+			} else {
+				row = append(row, selector.Data(item.Text, item.Data))
+			}
+		}
+		rows = append(rows, row)
+	}
+	selector.Inline(rows...)
+
+	// Кнопку "назад" добавляет сама стратегия
+	appendButtonRow(selector, nav.BackButton(screen))
+
+	text := "<b>" + menu.Title + "</b>"
+	if menu.Text != nil {
+		text = menu.Text(screen)
+	}
+
+	return text, selector, nil
+}
+
+// Show показывает экран: редактирует сообщение с кнопками или отправляет новое
+func (mr *MenuRegistry) Show(c tele.Context, nav Navigator, screen Screen) error {
+	if _, exists := mr.Get(screen.MenuID); !exists {
+		log.Printf("⚠️  Неизвестное меню %q, возвращаемся в %q", screen.MenuID, mr.root)
+		screen = Screen{Scope: screen.Scope, MenuID: mr.root}
+	}
+
+	// Стратегии без пути получают его из родителей, объявленных в реестре
+	if len(screen.Path) == 0 {
+		screen.Path = mr.pathTo(screen.MenuID)
+	}
+
+	text, markup, err := mr.Render(nav, screen)
+	if err != nil {
+		return err
+	}
+
+	if c.Callback() != nil {
+		// Сообщение то же, история остается привязанной к нему
+		return c.Edit(text, markup, tele.ModeHTML)
+	}
+
+	msg, err := c.Bot().Send(c.Recipient(), text, &tele.SendOptions{
+		ReplyMarkup: markup,
+		ParseMode:   tele.ModeHTML,
+		ThreadID:    screen.Scope.ThreadID,
+	})
+	if err != nil {
+		return err
+	}
+
+	if binder, ok := nav.(MessageBinder); ok {
+		if err := binder.BindMessage(screen, msg.ID); err != nil {
+			log.Printf("❌ Ошибка привязки навигации к сообщению для %v: %v", screen.Scope, err)
+		}
+	}
+	return nil
+}
+
+// Dispatch обрабатывает callback навигации: разбирает его стратегией и показывает экран
+func (mr *MenuRegistry) Dispatch(c tele.Context, nav Navigator) error {
+	scope := ScopeFromContext(c)
+
+	target, ok, err := nav.Resolve(scope, c.Callback().Data)
+	if !ok {
+		return c.Respond()
+	}
+	if errors.Is(err, ErrTamperedCallback) {
+		log.Printf("🚫 Подделанный callback от %v: %q", scope, c.Callback().Data)
+		return c.Respond(&tele.CallbackResponse{Text: "Кнопка недействительна"})
+	}
+	if err != nil {
+		log.Printf("❌ Ошибка навигации для %v: %v", scope, err)
+		return c.Respond(&tele.CallbackResponse{Text: "Не удалось открыть меню"})
+	}
+
+	if err := nav.Open(target); err != nil {
+		log.Printf("❌ Ошибка сохранения навигации для %v: %v", scope, err)
+	}
+
+	if err := mr.Show(c, nav, target); err != nil {
+		return err
+	}
+	// Без ответа Telegram продолжает показывать часики на кнопке
+	return c.Respond()
+}
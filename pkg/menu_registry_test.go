@@ -0,0 +1,168 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// stubNavigator - стратегия, которая разрешает любой callback в заранее заданный экран
+type stubNavigator struct {
+	target Screen
+	opened []Screen
+}
+
+func (sn *stubNavigator) MenuButton(from Screen, text, menuID string) *tele.Btn {
+	return &tele.Btn{Text: text, Unique: "m", Data: menuID}
+}
+
+func (sn *stubNavigator) BackButton(screen Screen) *tele.Btn {
+	return nil
+}
+
+func (sn *stubNavigator) Open(screen Screen) error {
+	sn.opened = append(sn.opened, screen)
+	return nil
+}
+
+func (sn *stubNavigator) Back(screen Screen) (Screen, bool, error) {
+	return Screen{}, false, nil
+}
+
+func (sn *stubNavigator) Resolve(scope NavigationScope, callbackData string) (Screen, bool, error) {
+	target := sn.target
+	target.Scope = scope
+	return target, true, nil
+}
+
+// callbackContext - контекст нажатия inline-кнопки, записывающий вызовы бота
+type callbackContext struct {
+	tele.Context
+	callback *tele.Callback
+	calls    []string
+}
+
+func (cc *callbackContext) Sender() *tele.User     { return cc.callback.Sender }
+func (cc *callbackContext) Chat() *tele.Chat       { return cc.callback.Message.Chat }
+func (cc *callbackContext) Message() *tele.Message { return cc.callback.Message }
+func (cc *callbackContext) Callback() *tele.Callback {
+	return cc.callback
+}
+
+func (cc *callbackContext) Edit(what interface{}, opts ...interface{}) error {
+	cc.calls = append(cc.calls, "edit")
+	return nil
+}
+
+func (cc *callbackContext) Respond(resp ...*tele.CallbackResponse) error {
+	cc.calls = append(cc.calls, "respond")
+	return nil
+}
+
+func newCallbackContext(userID int64, messageID int, data string) *callbackContext {
+	user := &tele.User{ID: userID}
+	return &callbackContext{callback: &tele.Callback{
+		Sender:  user,
+		Data:    data,
+		Message: &tele.Message{ID: messageID, Chat: &tele.Chat{ID: userID}},
+	}}
+}
+
+func TestMenuRegistryRegister(t *testing.T) {
+	registry := NewMenuRegistry("main")
+	if err := registry.Register(&Menu{ID: "main"}, &Menu{ID: "settings", Parent: "main"}); err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err := registry.Register(&Menu{}); err == nil {
+		t.Error("menu with empty id was registered")
+	}
+	if err := registry.Register(&Menu{ID: "settings"}); err == nil {
+		t.Error("duplicate menu was registered")
+	}
+	if menu, ok := registry.Get("settings"); !ok || menu.Parent != "main" {
+		t.Errorf("settings = %+v, %v: duplicate replaced the original", menu, ok)
+	}
+}
+
+func TestMenuRegistryValidate(t *testing.T) {
+	registry := NewMenuRegistry("main")
+	if err := registry.Register(
+		&Menu{ID: "settings", Parent: "profile"},
+		&Menu{ID: "help", Buttons: [][]MenuItem{{{Text: "FAQ", Target: "faq"}, {Text: "Написать", Data: "write"}}}},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	err := registry.Validate()
+	if err == nil {
+		t.Fatal("broken registry passed validation")
+	}
+	for _, want := range []string{
+		`root menu "main" is not registered`,
+		`menu "settings": parent "profile" is not registered`,
+		`menu "help": button "FAQ" points to unregistered menu "faq"`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("validation error misses %q:\n%v", want, err)
+		}
+	}
+	if strings.Contains(err.Error(), "write") {
+		t.Errorf("button without target was reported:\n%v", err)
+	}
+
+	if err := registry.Register(&Menu{ID: "main"}, &Menu{ID: "profile", Parent: "main"}, &Menu{ID: "faq", Parent: "help"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Validate(); err != nil {
+		t.Errorf("fixed registry: %v", err)
+	}
+}
+
+func TestMenuRegistryPathTo(t *testing.T) {
+	registry := NewMenuRegistry("main")
+	if err := registry.Register(
+		&Menu{ID: "main"},
+		&Menu{ID: "settings", Parent: "main"},
+		&Menu{ID: "privacy", Parent: "settings"},
+		&Menu{ID: "ping", Parent: "pong"},
+		&Menu{ID: "pong", Parent: "ping"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if path := registry.pathTo("privacy"); !sameMenus(path, []string{"main", "settings", "privacy"}) {
+		t.Errorf("pathTo(privacy) = %v", path)
+	}
+	// Кольцо родителей не должно зацикливать построение пути
+	if path := registry.pathTo("ping"); !sameMenus(path, []string{"pong", "ping"}) {
+		t.Errorf("pathTo(ping) = %v", path)
+	}
+	// Незарегистрированное меню остается единственным элементом пути
+	if path := registry.pathTo("ghost"); !sameMenus(path, []string{"ghost"}) {
+		t.Errorf("pathTo(ghost) = %v", path)
+	}
+}
+
+func TestMenuRegistryDispatchRespondsAfterShow(t *testing.T) {
+	registry := NewMenuRegistry("main")
+	if err := registry.Register(
+		&Menu{ID: "main", Title: "Главное"},
+		&Menu{ID: "settings", Title: "Настройки", Parent: "main"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	nav := &stubNavigator{target: Screen{MenuID: "settings"}}
+	c := newCallbackContext(42, 7, "m|settings")
+	if err := registry.Dispatch(c, nav); err != nil {
+		t.Fatalf("dispatch: %v", err)
+	}
+
+	if !sameMenus(c.calls, []string{"edit", "respond"}) {
+		t.Errorf("calls = %v, want edit then respond", c.calls)
+	}
+	if len(nav.opened) != 1 || nav.opened[0].Scope != UserScope(42).Message(7) {
+		t.Errorf("opened = %+v, want settings in message 7", nav.opened)
+	}
+}
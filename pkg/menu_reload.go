@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MenuReloader - горячая перезагрузка описания меню без рестарта бота
+// Файл опрашивается с интервалом, новое дерево валидируется и подменяется атомарно
+type MenuReloader struct {
+	path     string
+	interval time.Duration
+
+	registry   atomic.Pointer[MenuRegistry]
+	onValidate []func(*MenuDefinition, *MenuRegistry) error
+	onReload   []func(*MenuDefinition)
+
+	// Реестр и зависящая от него иерархия подменяются под одной блокировкой записи,
+	// обработчики в View держат блокировку чтения и видят их согласованными
+	swap sync.RWMutex
+
+	// Состояние файла на момент последней проверки
+	modTime  time.Time
+	size     int64
+	checksum [sha256.Size]byte
+
+	// Отклоненный файл: пока он не изменился, его не разбираем и не логируем повторно
+	rejected         bool
+	rejectedModTime  time.Time
+	rejectedSize     int64
+	rejectedChecksum [sha256.Size]byte
+
+	reloads  atomic.Int64
+	failures atomic.Int64
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+func NewMenuReloader(path string, interval time.Duration) *MenuReloader {
+	return &MenuReloader{
+		path:     path,
+		interval: interval,
+		stop:     make(chan struct{}),
+	}
+}
+
+// OnValidate регистрирует проверку нового описания, вызываемую до подмены дерева
+// Ошибка проверки отклоняет описание так же, как ошибка разбора файла
+func (mr *MenuReloader) OnValidate(fn func(*MenuDefinition, *MenuRegistry) error) {
+	mr.onValidate = append(mr.onValidate, fn)
+}
+
+// OnReload регистрирует обработчик, вызываемый после успешной подмены дерева
+// Например, для обновления иерархии HierarchicalNavigation.
+// Обработчик выполняется под блокировкой подмены и не должен вызывать View.
+func (mr *MenuReloader) OnReload(fn func(*MenuDefinition)) {
+	mr.onReload = append(mr.onReload, fn)
+}
+
+// Start загружает файл и запускает опрос изменений
+// Первая загрузка обязана быть успешной - без меню бот работать не может
+func (mr *MenuReloader) Start() error {
+	if _, err := mr.reload(); err != nil {
+		return err
+	}
+
+	mr.wg.Add(1)
+	go mr.pollRoutine()
+	return nil
+}
+
+// Close останавливает опрос файла
+func (mr *MenuReloader) Close() {
+	mr.stopOnce.Do(func() {
+		close(mr.stop)
+	})
+	mr.wg.Wait()
+}
+
+// Registry реализует MenuSource: возвращает текущую версию реестра
+func (mr *MenuReloader) Registry() *MenuRegistry {
+	return mr.registry.Load()
+}
+
+// View реализует MenuSource: пока выполняется fn, реестр и иерархия не подменяются
+func (mr *MenuReloader) View(fn func(*MenuRegistry) error) error {
+	mr.swap.RLock()
+	defer mr.swap.RUnlock()
+
+	return fn(mr.registry.Load())
+}
+
+// Stats возвращает счетчики перезагрузок
+func (mr *MenuReloader) Stats() map[string]interface{} {
+	return map[string]interface{}{
+		"path":            mr.path,
+		"reloads":         mr.reloads.Load(),
+		"failed_reloads":  mr.failures.Load(),
+		"poll_interval_s": mr.interval.Seconds(),
+	}
+}
+
+// pollRoutine периодически проверяет файл на изменения
+func (mr *MenuReloader) pollRoutine() {
+	defer mr.wg.Done()
+
+	ticker := time.NewTicker(mr.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			changed, err := mr.reload()
+			if err != nil {
+				mr.failures.Add(1)
+				log.Printf("❌ Описание меню %s отклонено, продолжаем работать на предыдущем: %v", mr.path, err)
+			} else if changed {
+				log.Printf("🔄 Описание меню %s перезагружено", mr.path)
+			}
+		case <-mr.stop:
+			return
+		}
+	}
+}
+
+// reload перечитывает файл, если он изменился, и подменяет реестр
+func (mr *MenuReloader) reload() (bool, error) {
+	info, err := os.Stat(mr.path)
+	if err != nil {
+		return false, err
+	}
+
+	// Дешевая проверка по метаданным, чтобы не читать файл каждый тик
+	if mr.registry.Load() != nil && info.ModTime().Equal(mr.modTime) && info.Size() == mr.size {
+		return false, nil
+	}
+	if mr.rejected && info.ModTime().Equal(mr.rejectedModTime) && info.Size() == mr.rejectedSize {
+		return false, nil
+	}
+
+	data, err := os.ReadFile(mr.path)
+	if err != nil {
+		return false, err
+	}
+
+	checksum := sha256.Sum256(data)
+	if mr.registry.Load() != nil && bytes.Equal(checksum[:], mr.checksum[:]) {
+		mr.modTime, mr.size, mr.rejected = info.ModTime(), info.Size(), false
+		return false, nil
+	}
+	// Файл тронули, но содержимое то же, что уже отклонили
+	if mr.rejected && bytes.Equal(checksum[:], mr.rejectedChecksum[:]) {
+		mr.rejectedModTime, mr.rejectedSize = info.ModTime(), info.Size()
+		return false, nil
+	}
+
+	md, registry, err := mr.parse(data)
+	if err != nil {
+		mr.rejected = true
+		mr.rejectedModTime, mr.rejectedSize, mr.rejectedChecksum = info.ModTime(), info.Size(), checksum
+		return false, err
+	}
+
+	// Старые кнопки в истории чата ссылаются на меню из предыдущей версии
+	// Оставляем ее как запасную, но без цепочки более старых версий
+	if old := mr.registry.Load(); old != nil {
+		previous := *old
+		previous.previous = nil
+		registry.previous = &previous
+	}
+
+	mr.swap.Lock()
+	mr.registry.Store(registry)
+	for _, fn := range mr.onReload {
+		fn(md)
+	}
+	mr.swap.Unlock()
+
+	mr.modTime, mr.size, mr.checksum = info.ModTime(), info.Size(), checksum
+	mr.rejected = false
+	mr.reloads.Add(1)
+
+	return true, nil
+}
+
+// parse разбирает описание меню и прогоняет проверки OnValidate
+func (mr *MenuReloader) parse(data []byte) (*MenuDefinition, *MenuRegistry, error) {
+	md, err := ParseMenuDefinition(mr.path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	registry, err := md.Registry()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", mr.path, err)
+	}
+
+	for _, fn := range mr.onValidate {
+		if err := fn(md, registry); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", mr.path, err)
+		}
+	}
+	return md, registry, nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMenuReloaderRejectsInvalidHierarchy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "menus.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Настройки, menu: settings}, {text: Помощь, menu: help}]
+  - id: settings
+    parent: main
+  - id: help
+    parent: main
+`)
+	reloader := NewMenuReloader(path, time.Hour)
+	nav := NewHierarchicalNavigationFromDefinition(&MenuDefinition{})
+	nav.Follow(reloader)
+	if err := reloader.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer reloader.Close()
+	loaded := reloader.Registry()
+
+	// Кольцо родителей: settings и about оторваны от корня
+	write(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    parent: about
+  - id: about
+    parent: settings
+`)
+	if _, err := reloader.reload(); err == nil {
+		t.Fatal("cyclic hierarchy was accepted")
+	}
+	if reloader.Registry() != loaded {
+		t.Error("registry was swapped despite invalid hierarchy")
+	}
+	if parent, _ := nav.GetParent("settings"); parent != "main" {
+		t.Errorf("settings parent = %q after rejected reload", parent)
+	}
+
+	write(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    parent: main
+`)
+	if _, err := reloader.reload(); err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	if nav.HasParent("help") {
+		t.Error("link of removed menu survived reload")
+	}
+	if screen, _, _ := nav.Resolve(NavigationScope{}, "nav_back|help"); screen.MenuID != "main" {
+		t.Errorf("back from removed menu = %q, want main", screen.MenuID)
+	}
+}
+
+func TestMenuReloaderSkipsRejectedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "menus.yaml")
+	stamp := time.Now().Add(-time.Hour)
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		// Время изменения сдвигаем явно, чтобы проверка по метаданным не зависела от точности ФС
+		stamp = stamp.Add(time.Minute)
+		if err := os.Chtimes(path, stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+version: 1
+menus:
+  - id: main
+`)
+	reloader := NewMenuReloader(path, time.Hour)
+	var validations int
+	reloader.OnValidate(func(md *MenuDefinition, menus *MenuRegistry) error {
+		validations++
+		if _, ok := menus.Get("beta"); ok {
+			return errors.New("beta menu is not released yet")
+		}
+		return nil
+	})
+	if err := reloader.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer reloader.Close()
+
+	broken := `
+version: 1
+menus:
+  - id: main
+  - id: beta
+    parent: main
+`
+	write(broken)
+	if _, err := reloader.reload(); err == nil {
+		t.Fatal("file rejected by OnValidate was accepted")
+	}
+
+	// Тот же файл на следующих тиках не разбирается и не считается новой ошибкой
+	for i := 0; i < 3; i++ {
+		if changed, err := reloader.reload(); changed || err != nil {
+			t.Fatalf("poll %d of the rejected file = %v, %v", i, changed, err)
+		}
+	}
+	// Файл перезаписан тем же содержимым - отличается только время изменения
+	write(broken)
+	if changed, err := reloader.reload(); changed || err != nil {
+		t.Fatalf("rewritten rejected file = %v, %v", changed, err)
+	}
+	if validations != 2 {
+		t.Errorf("validated %d times, want 2: initial load and the first look at the rejected file", validations)
+	}
+
+	write(`
+version: 1
+menus:
+  - id: main
+  - id: settings
+    parent: main
+`)
+	if changed, err := reloader.reload(); !changed || err != nil {
+		t.Fatalf("fixed file = %v, %v", changed, err)
+	}
+	if _, ok := reloader.Registry().Get("settings"); !ok {
+		t.Error("fixed file was not loaded")
+	}
+}
+
+func TestMenuReloaderSwapsRegistryWithHierarchy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "menus.yaml")
+	write := func(data string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    parent: main
+`)
+	reloader := NewMenuReloader(path, time.Hour)
+	nav := NewHierarchicalNavigationFromDefinition(&MenuDefinition{})
+	nav.Follow(reloader)
+	if err := reloader.Start(); err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	defer reloader.Close()
+
+	write(`
+version: 1
+menus:
+  - id: main
+    buttons:
+      - [{text: Настройки, menu: settings}, {text: Профиль, menu: profile}]
+  - id: profile
+    parent: main
+    buttons:
+      - [{text: Настройки, menu: settings}]
+  - id: settings
+    parent: profile
+`)
+
+	done := make(chan error, 1)
+	err := reloader.View(func(menus *MenuRegistry) error {
+		go func() {
+			_, err := reloader.reload()
+			done <- err
+		}()
+
+		// Пока обработчик работает, перезагрузка ждет - реестр и иерархия остаются прежними
+		select {
+		case err := <-done:
+			t.Errorf("reload finished during View: %v", err)
+		case <-time.After(50 * time.Millisecond):
+		}
+		if _, ok := menus.Get("profile"); ok {
+			t.Error("handler sees the new registry")
+		}
+		if parent, _ := nav.GetParent("settings"); parent != "main" {
+			t.Errorf("handler sees the new hierarchy: settings parent = %q", parent)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("reload: %v", err)
+	}
+	err = reloader.View(func(menus *MenuRegistry) error {
+		if _, ok := menus.Get("profile"); !ok {
+			t.Error("registry was not swapped")
+		}
+		if parent, _ := nav.GetParent("settings"); parent != "profile" {
+			t.Errorf("settings parent = %q after reload, want profile", parent)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
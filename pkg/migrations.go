@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Миграции схемы лежат в migrations/<диалект>/NNNN_название.sql и встраиваются в бинарник.
+// Примененные версии записываются в schema_version, повторный запуск ничего не делает.
+//
+//go:embed migrations/postgres/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+// Migration - одна миграция схемы
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// migrationDialect - отличия SQL между базами
+type migrationDialect struct {
+	dir           string
+	createVersion string
+	insertVersion string
+	lock          string // Блокировка от параллельного запуска нескольких ботов, может быть пустой
+}
+
+var (
+	postgresMigrations = migrationDialect{
+		dir: "migrations/postgres",
+		createVersion: `
+    CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+		insertVersion: "INSERT INTO schema_version (version, name) VALUES ($1, $2)",
+		lock:          "SELECT pg_advisory_xact_lock(7461062)",
+	}
+
+	sqliteMigrations = migrationDialect{
+		dir: "migrations/sqlite",
+		createVersion: `
+    CREATE TABLE IF NOT EXISTS schema_version (
+        version INTEGER PRIMARY KEY,
+        name TEXT NOT NULL,
+        applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    )`,
+		insertVersion: "INSERT INTO schema_version (version, name) VALUES (?, ?)",
+	}
+)
+
+// Migrator применяет миграции по порядку версий
+type Migrator struct {
+	db         *sql.DB
+	dialect    migrationDialect
+	migrations []Migration
+}
+
+// NewPostgresMigrator создает мигратор для PostgreSQL
+func NewPostgresMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, postgresMigrations)
+}
+
+// NewSQLiteMigrator создает мигратор для SQLite
+func NewSQLiteMigrator(db *sql.DB) (*Migrator, error) {
+	return newMigrator(db, sqliteMigrations)
+}
+
+func newMigrator(db *sql.DB, dialect migrationDialect) (*Migrator, error) {
+	migrations, err := LoadMigrations(migrationFiles, dialect.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		dialect:    dialect,
+		migrations: migrations,
+	}, nil
+}
+
+// LoadMigrations читает миграции из каталога и сортирует их по версии
+// Имя файла - NNNN_название.sql, версии не должны повторяться
+func LoadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("read migrations %s: %v", dir, err)
+	}
+
+	var migrations []Migration
+	seen := make(map[int]string)
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".sql") {
+			continue
+		}
+
+		base := strings.TrimSuffix(entry.Name(), ".sql")
+		number, name, found := strings.Cut(base, "_")
+		version, err := strconv.Atoi(number)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: file name must look like 0001_name.sql", entry.Name())
+		}
+		if other, exists := seen[version]; exists {
+			return nil, fmt.Errorf("migration %s: version %d already used by %s", entry.Name(), version, other)
+		}
+		seen[version] = entry.Name()
+
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("read migration %s: %v", entry.Name(), err)
+		}
+
+		migrations = append(migrations, Migration{
+			Version: version,
+			Name:    name,
+			SQL:     string(data),
+		})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Migrations возвращает все известные миграции по порядку
+func (m *Migrator) Migrations() []Migration {
+	return append([]Migration(nil), m.migrations...)
+}
+
+// Version возвращает последнюю примененную версию схемы, 0 - схема пустая
+func (m *Migrator) Version(ctx context.Context) (int, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	for v := range applied {
+		if v > version {
+			version = v
+		}
+	}
+	return version, nil
+}
+
+// Pending возвращает миграции, которые еще не применены
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx, m.db)
+	if err != nil {
+		return nil, err
+	}
+	return m.pending(applied), nil
+}
+
+// Up применяет все недостающие миграции, каждую в своей транзакции
+// Возвращает примененные миграции, при ошибке - те, что успели примениться
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+
+	for {
+		migration, ok, err := m.applyNext(ctx)
+		if err != nil {
+			return done, err
+		}
+		if !ok {
+			return done, nil
+		}
+		log.Printf("✅ Миграция %04d_%s применена", migration.Version, migration.Name)
+		done = append(done, migration)
+	}
+}
+
+// applyNext применяет первую непримененную миграцию
+// Список примененных читается внутри транзакции под блокировкой, поэтому два бота,
+// стартующих одновременно, не применят одну миграцию дважды
+func (m *Migrator) applyNext(ctx context.Context) (Migration, bool, error) {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return Migration{}, false, storeUnavailable("schema", NavigationScope{}, err)
+	}
+	defer tx.Rollback()
+
+	if m.dialect.lock != "" {
+		if _, err := tx.ExecContext(ctx, m.dialect.lock); err != nil {
+			return Migration{}, false, storeUnavailable("schema", NavigationScope{}, err)
+		}
+	}
+
+	applied, err := m.applied(ctx, tx)
+	if err != nil {
+		return Migration{}, false, err
+	}
+
+	pending := m.pending(applied)
+	if len(pending) == 0 {
+		return Migration{}, false, nil
+	}
+	migration := pending[0]
+
+	if _, err := tx.ExecContext(ctx, migration.SQL); err != nil {
+		return migration, false, storeUnavailable("schema", NavigationScope{},
+			fmt.Errorf("migration %04d_%s: %v", migration.Version, migration.Name, err))
+	}
+	if _, err := tx.ExecContext(ctx, m.dialect.insertVersion, migration.Version, migration.Name); err != nil {
+		return migration, false, storeUnavailable("schema", NavigationScope{}, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return migration, false, storeUnavailable("schema", NavigationScope{}, err)
+	}
+	return migration, true, nil
+}
+
+// migrationQuerier - общее у *sql.DB и *sql.Tx
+type migrationQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// applied возвращает примененные версии, при необходимости создает schema_version
+func (m *Migrator) applied(ctx context.Context, q migrationQuerier) (map[int]bool, error) {
+	if _, err := q.ExecContext(ctx, m.dialect.createVersion); err != nil {
+		return nil, storeUnavailable("schema", NavigationScope{}, err)
+	}
+
+	rows, err := q.QueryContext(ctx, "SELECT version FROM schema_version")
+	if err != nil {
+		return nil, storeUnavailable("schema", NavigationScope{}, err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, storeUnavailable("schema", NavigationScope{}, err)
+		}
+		applied[version] = true
+	}
+	return applied, storeUnavailable("schema", NavigationScope{}, rows.Err())
+}
+
+func (m *Migrator) pending(applied map[int]bool) []Migration {
+	var pending []Migration
+	for _, migration := range m.migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending
+}
+
+// RunMigrateCommand выполняет команду миграций из кода бота или отдельной утилиты:
+//
+//	pending - напечатать непримененные миграции вместе с SQL (по умолчанию)
+//	status  - текущая версия и список миграций
+//	up      - напечатать и применить непримененные миграции
+func RunMigrateCommand(ctx context.Context, w io.Writer, m *Migrator, args []string) error {
+	command := "pending"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "pending", "up":
+		pending, err := m.Pending(ctx)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Fprintln(w, "Схема актуальна, миграций нет")
+			return nil
+		}
+
+		for _, migration := range pending {
+			fmt.Fprintf(w, "-- %04d_%s\n%s\n", migration.Version, migration.Name, strings.TrimSpace(migration.SQL))
+		}
+		if command == "pending" {
+			return nil
+		}
+
+		done, err := m.Up(ctx)
+		fmt.Fprintf(w, "Применено миграций: %d\n", len(done))
+		return err
+
+	case "status":
+		version, err := m.Version(ctx)
+		if err != nil {
+			return err
+		}
+		applied, err := m.applied(ctx, m.db)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(w, "Версия схемы: %d\n", version)
+		for _, migration := range m.migrations {
+			mark := "  "
+			if applied[migration.Version] {
+				mark = "✅"
+			}
+			fmt.Fprintf(w, "%s %04d_%s\n", mark, migration.Version, migration.Name)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected pending, status or up", command)
+	}
+}
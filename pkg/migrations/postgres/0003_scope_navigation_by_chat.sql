@@ -0,0 +1,10 @@
+-- Отдельный стек для каждого чата и темы форума
+-- Старые записи относятся к личному чату: chat_id = user_id
+-- IF [NOT] EXISTS - повторный запуск после частичного ручного применения не падает
+ALTER TABLE user_navigation ADD COLUMN IF NOT EXISTS chat_id BIGINT;
+UPDATE user_navigation SET chat_id = user_id WHERE chat_id IS NULL;
+ALTER TABLE user_navigation ALTER COLUMN chat_id SET NOT NULL;
+ALTER TABLE user_navigation ADD COLUMN IF NOT EXISTS thread_id BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE user_navigation DROP CONSTRAINT IF EXISTS user_navigation_pkey;
+ALTER TABLE user_navigation ADD PRIMARY KEY (user_id, chat_id, thread_id);
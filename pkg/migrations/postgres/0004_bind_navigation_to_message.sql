@@ -0,0 +1,6 @@
+-- Отдельный стек для каждого сообщения с меню
+-- Старые записи остаются общими для чата: message_id = 0
+ALTER TABLE user_navigation ADD COLUMN IF NOT EXISTS message_id BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE user_navigation DROP CONSTRAINT IF EXISTS user_navigation_pkey;
+ALTER TABLE user_navigation ADD PRIMARY KEY (user_id, chat_id, thread_id, message_id);
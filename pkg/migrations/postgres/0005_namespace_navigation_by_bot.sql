@@ -0,0 +1,6 @@
+-- Несколько ботов в одной базе: у каждого свои стеки и свой срок хранения
+-- Старые записи принадлежат боту по умолчанию: bot = ''
+ALTER TABLE user_navigation ADD COLUMN IF NOT EXISTS bot TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE user_navigation DROP CONSTRAINT IF EXISTS user_navigation_pkey;
+ALTER TABLE user_navigation ADD PRIMARY KEY (bot, user_id, chat_id, thread_id, message_id);
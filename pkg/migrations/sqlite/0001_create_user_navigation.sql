@@ -0,0 +1,10 @@
+-- Стек навигации пользователя
+CREATE TABLE IF NOT EXISTS user_navigation (
+    user_id INTEGER PRIMARY KEY,
+    menu_stack TEXT NOT NULL,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Индекс для быстрой очистки старых записей
+CREATE INDEX IF NOT EXISTS idx_user_navigation_updated_at
+ON user_navigation(updated_at);
@@ -0,0 +1,3 @@
+-- Версия записи для оптимистичной блокировки и время клика для слияния конфликтов
+ALTER TABLE user_navigation ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_navigation ADD COLUMN clicked_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00';
@@ -0,0 +1,22 @@
+-- Отдельный стек для каждого чата и темы форума
+-- SQLite не умеет менять первичный ключ, поэтому таблица пересоздается.
+-- Старые записи относятся к личному чату: chat_id = user_id
+CREATE TABLE user_navigation_scoped (
+    user_id INTEGER NOT NULL,
+    chat_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL DEFAULT 0,
+    menu_stack TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 0,
+    clicked_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00',
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, chat_id, thread_id)
+);
+
+INSERT INTO user_navigation_scoped (user_id, chat_id, thread_id, menu_stack, version, clicked_at, updated_at)
+SELECT user_id, user_id, 0, menu_stack, version, clicked_at, updated_at FROM user_navigation;
+
+DROP TABLE user_navigation;
+ALTER TABLE user_navigation_scoped RENAME TO user_navigation;
+
+CREATE INDEX IF NOT EXISTS idx_user_navigation_updated_at
+ON user_navigation(updated_at);
@@ -0,0 +1,24 @@
+-- Несколько ботов в одной базе: у каждого свои стеки и свой срок хранения
+-- SQLite не умеет менять первичный ключ, поэтому таблица пересоздается.
+-- Старые записи принадлежат боту по умолчанию: bot = ''
+CREATE TABLE user_navigation_bots (
+    bot TEXT NOT NULL DEFAULT '',
+    user_id INTEGER NOT NULL,
+    chat_id INTEGER NOT NULL,
+    thread_id INTEGER NOT NULL DEFAULT 0,
+    message_id INTEGER NOT NULL DEFAULT 0,
+    menu_stack TEXT NOT NULL,
+    version INTEGER NOT NULL DEFAULT 0,
+    clicked_at TIMESTAMP NOT NULL DEFAULT '1970-01-01 00:00:00',
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (bot, user_id, chat_id, thread_id, message_id)
+);
+
+INSERT INTO user_navigation_bots (bot, user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at)
+SELECT '', user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at FROM user_navigation;
+
+DROP TABLE user_navigation;
+ALTER TABLE user_navigation_bots RENAME TO user_navigation;
+
+CREATE INDEX IF NOT EXISTS idx_user_navigation_updated_at
+ON user_navigation(updated_at);
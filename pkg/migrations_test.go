@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"m/0002_second.sql": {Data: []byte("SELECT 2")},
+		"m/0001_first.sql":  {Data: []byte("SELECT 1")},
+		"m/README.md":       {Data: []byte("not a migration")},
+	}
+
+	migrations, err := LoadMigrations(fsys, "m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Name != "first" || migrations[1].Version != 2 || migrations[1].SQL != "SELECT 2" {
+		t.Errorf("migrations = %+v", migrations)
+	}
+}
+
+func TestLoadMigrationsErrors(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"must look like 0001_name.sql": {"m/first.sql": {}},
+		"version 1 already used":       {"m/0001_a.sql": {}, "m/0001_b.sql": {}},
+	}
+	for want, fsys := range tests {
+		if _, err := LoadMigrations(fsys, "m"); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("error = %v, want %q", err, want)
+		}
+	}
+}
+
+// TestEmbeddedMigrations сверяет миграции диалектов между собой
+// Миграции PostgreSQL меняют таблицу без транзакционного пересоздания, поэтому должны
+// переживать повторный запуск по уже частично измененной схеме.
+func TestEmbeddedMigrations(t *testing.T) {
+	postgres, err := LoadMigrations(migrationFiles, postgresMigrations.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlite, err := LoadMigrations(migrationFiles, sqliteMigrations.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(postgres) != len(sqlite) {
+		t.Fatalf("postgres has %d migrations, sqlite %d", len(postgres), len(sqlite))
+	}
+	for i := range postgres {
+		if postgres[i].Version != sqlite[i].Version || postgres[i].Name != sqlite[i].Name {
+			t.Errorf("migration %d: postgres %04d_%s, sqlite %04d_%s", i,
+				postgres[i].Version, postgres[i].Name, sqlite[i].Version, sqlite[i].Name)
+		}
+	}
+
+	unguarded := regexp.MustCompile(`(?i)(ADD COLUMN (?:IF NOT EXISTS)?|DROP CONSTRAINT (?:IF EXISTS)?)`)
+	for _, migration := range postgres {
+		for _, match := range unguarded.FindAllString(migration.SQL, -1) {
+			if !strings.Contains(strings.ToUpper(match), "EXISTS") {
+				t.Errorf("postgres %04d_%s: %q without IF [NOT] EXISTS", migration.Version, migration.Name, match)
+			}
+		}
+	}
+}
+
+func TestRunMigrateCommandUnknown(t *testing.T) {
+	err := RunMigrateCommand(context.Background(), io.Discard, &Migrator{}, []string{"down"})
+	if err == nil || !strings.Contains(err.Error(), "unknown migrate command") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestMigrationsUp(t *testing.T) {
+	for _, dialect := range []string{"postgres", "sqlite"} {
+		t.Run(dialect, func(t *testing.T) {
+			db := openTestDB(t, dialect)
+			ctx := context.Background()
+
+			m, err := newMigrator(db, map[string]migrationDialect{"postgres": postgresMigrations, "sqlite": sqliteMigrations}[dialect])
+			if err != nil {
+				t.Fatal(err)
+			}
+			done, err := m.Up(ctx)
+			if err != nil || len(done) != len(m.Migrations()) {
+				t.Fatalf("up = %d migrations, %v", len(done), err)
+			}
+			if again, err := m.Up(ctx); err != nil || len(again) != 0 {
+				t.Errorf("second up = %d migrations, %v", len(again), err)
+			}
+			if version, err := m.Version(ctx); err != nil || version != done[len(done)-1].Version {
+				t.Errorf("version = %d, %v", version, err)
+			}
+		})
+	}
+}
+
+// TestPostgresMigrationsPartiallyApplied - колонки 0003 уже добавлены вручную, миграции не падают
+func TestPostgresMigrationsPartiallyApplied(t *testing.T) {
+	db := openTestDB(t, "postgres")
+	ctx := context.Background()
+
+	_, err := db.ExecContext(ctx, `
+    CREATE TABLE user_navigation (
+        user_id BIGINT PRIMARY KEY,
+        chat_id BIGINT,
+        menu_stack JSONB NOT NULL,
+        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    )`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := NewPostgresMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(ctx); err != nil {
+		t.Fatalf("up: %v", err)
+	}
+}
+
+// openTestDB открывает пустую базу для интеграционных тестов
+// PostgreSQL - NAVIGATION_TEST_POSTGRES (DSN), тест идет в отдельной схеме, которая удаляется после него.
+// SQLite - если в бинарник подключен драйвер "sqlite3" или "sqlite". Иначе тест пропускается.
+func openTestDB(t *testing.T, dialect string) *sql.DB {
+	t.Helper()
+
+	var db *sql.DB
+	var err error
+	switch dialect {
+	case "postgres":
+		dsn := os.Getenv("NAVIGATION_TEST_POSTGRES")
+		if dsn == "" {
+			t.Skip("NAVIGATION_TEST_POSTGRES is not set")
+		}
+		db, err = sql.Open("postgres", dsn)
+	case "sqlite":
+		driver := ""
+		for _, name := range sql.Drivers() {
+			if name == "sqlite3" || name == "sqlite" {
+				driver = name
+			}
+		}
+		if driver == "" {
+			t.Skip("no sqlite driver registered")
+		}
+		db, err = sql.Open(driver, ":memory:")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Одно соединение: у SQLite :memory: своя база на соединение, у PostgreSQL - search_path
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+
+	if dialect == "postgres" {
+		schema := fmt.Sprintf("navigation_test_%d", time.Now().UnixNano())
+		if _, err := db.Exec("CREATE SCHEMA " + schema + "; SET search_path TO " + schema); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Exec("DROP SCHEMA " + schema + " CASCADE") })
+	}
+	return db
+}
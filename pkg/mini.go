@@ -10,23 +10,36 @@ import (
 // UltraSimpleNavigation - максимально простая навигация
 // Принцип: каждая кнопка знает куда она ведет назад
 type UltraSimpleNavigation struct {
-	// Больше ничего не нужно!
+	envelope callbackEnvelope // Версия и подпись callback_data
 }
 
 func NewUltraSimpleNavigation() *UltraSimpleNavigation {
-	return &UltraSimpleNavigation{}
+	return &UltraSimpleNavigation{envelope: newCallbackEnvelope()}
+}
+
+// SetCallbackVersions задает версионирование callback_data и перенаправления меню
+func (usn *UltraSimpleNavigation) SetCallbackVersions(versions *CallbackVersions) {
+	usn.envelope.versions = versions
+}
+
+// SetSigner включает подпись callback_data секретом сервера
+// После включения кнопки без подписи перестают приниматься
+func (usn *UltraSimpleNavigation) SetSigner(signer *CallbackSigner) {
+	usn.envelope.signer = signer
 }
 
 // CreateBackButton создает кнопку "назад" с указанием куда вернуться
 func (usn *UltraSimpleNavigation) CreateBackButton(returnTo string) *tele.Btn {
 	selector := &tele.ReplyMarkup{}
-	return selector.Data("⬅️ Назад", "back_to:"+returnTo)
+	btn := selector.Data("⬅️ Назад", usn.envelope.wrap("back_to:"+returnTo))
+	return &btn
 }
 
 // CreateMenuButton создает кнопку перехода в меню
 func (usn *UltraSimpleNavigation) CreateMenuButton(text, menuID string) *tele.Btn {
 	selector := &tele.ReplyMarkup{}
-	return selector.Data(text, "goto:"+menuID)
+	btn := selector.Data(text, usn.envelope.wrap("goto:"+menuID))
+	return &btn
 }
 
 // AddBackButton добавляет кнопку "назад" с указанием куда возвращаться
@@ -35,35 +48,102 @@ func (usn *UltraSimpleNavigation) AddBackButton(keyboard *tele.ReplyMarkup, retu
 		return // Не добавляем кнопку в главное меню
 	}
 
-	backBtn := usn.CreateBackButton(returnTo)
-	if keyboard.InlineKeyboard == nil {
-		keyboard.InlineKeyboard = make([][]tele.Btn, 0)
-	}
-	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tele.Btn{*backBtn})
+	appendButtonRow(keyboard, usn.CreateBackButton(returnTo))
 }
 
 // IsBackButton проверяет, является ли это кнопкой "назад"
+// Кнопка с неверной подписью кнопкой "назад" не считается
 func (usn *UltraSimpleNavigation) IsBackButton(callbackData string) (bool, string) {
-	if strings.HasPrefix(callbackData, "back_to:") {
-		returnTo := strings.TrimPrefix(callbackData, "back_to:")
-		return true, returnTo
+	payload, err := usn.envelope.unwrap(callbackData)
+	if err != nil {
+		return false, ""
 	}
-	return false, ""
+	return usn.parseBack(payload)
 }
 
 // IsMenuButton проверяет, является ли это кнопкой меню
+// Кнопка с неверной подписью кнопкой меню не считается
 func (usn *UltraSimpleNavigation) IsMenuButton(callbackData string) (bool, string) {
-	if strings.HasPrefix(callbackData, "goto:") {
-		menuID := strings.TrimPrefix(callbackData, "goto:")
+	payload, err := usn.envelope.unwrap(callbackData)
+	if err != nil {
+		return false, ""
+	}
+	return usn.parseMenu(payload)
+}
+
+// parseBack разбирает проверенную полезную нагрузку "back_to:"
+func (usn *UltraSimpleNavigation) parseBack(payload string) (bool, string) {
+	if strings.HasPrefix(payload, "back_to:") {
+		returnTo := strings.TrimPrefix(payload, "back_to:")
+		return true, returnTo
+	}
+	return false, ""
+}
+
+// parseMenu разбирает проверенную полезную нагрузку "goto:"
+func (usn *UltraSimpleNavigation) parseMenu(payload string) (bool, string) {
+	if strings.HasPrefix(payload, "goto:") {
+		menuID := strings.TrimPrefix(payload, "goto:")
 		return true, menuID
 	}
 	return false, ""
 }
 
+// MenuButton реализует Navigator: кнопка перехода в меню
+func (usn *UltraSimpleNavigation) MenuButton(from Screen, text, menuID string) *tele.Btn {
+	return usn.CreateMenuButton(text, menuID)
+}
+
+// BackButton реализует Navigator: кнопка ведет на уровень выше по пути экрана
+func (usn *UltraSimpleNavigation) BackButton(screen Screen) *tele.Btn {
+	parent, ok := ParentScreen(screen)
+	if !ok {
+		return nil
+	}
+	return usn.CreateBackButton(parent.MenuID)
+}
+
+// Open реализует Navigator: состояние не хранится, запоминать нечего
+func (usn *UltraSimpleNavigation) Open(screen Screen) error {
+	return nil
+}
+
+// Back реализует Navigator: возвращаемся по пути экрана
+func (usn *UltraSimpleNavigation) Back(screen Screen) (Screen, bool, error) {
+	parent, ok := ParentScreen(screen)
+	return parent, ok, nil
+}
+
+// Resolve реализует Navigator: разбирает "back_to:" и "goto:"
+// Навигационный callback с неверной подписью возвращает ErrTamperedCallback
+func (usn *UltraSimpleNavigation) Resolve(scope NavigationScope, callbackData string) (Screen, bool, error) {
+	data := normalizeCallbackData(callbackData)
+
+	payload, err := usn.envelope.unwrap(data)
+	if err != nil {
+		peeked := usn.envelope.peek(data)
+		if strings.HasPrefix(peeked, "back_to:") || strings.HasPrefix(peeked, "goto:") {
+			return Screen{}, true, err
+		}
+		return Screen{}, false, nil
+	}
+
+	if isBack, returnTo := usn.parseBack(payload); isBack {
+		return Screen{Scope: scope, MenuID: usn.envelope.migrateMenu(returnTo)}, true, nil
+	}
+
+	if isMenu, menuID := usn.parseMenu(payload); isMenu {
+		return Screen{Scope: scope, MenuID: usn.envelope.migrateMenu(menuID)}, true, nil
+	}
+
+	return Screen{}, false, nil
+}
+
 // Пример использования - СУПЕР ПРОСТОЙ БОТ
 type UltraBot struct {
 	*tele.Bot
-	nav *UltraSimpleNavigation
+	nav   *UltraSimpleNavigation
+	menus *MenuRegistry
 }
 
 func NewUltraBot(token string) (*UltraBot, error) {
@@ -76,8 +156,17 @@ func NewUltraBot(token string) (*UltraBot, error) {
 	}
 
 	ub := &UltraBot{
-		Bot: bot,
-		nav: NewUltraSimpleNavigation(),
+		Bot:   bot,
+		nav:   NewUltraSimpleNavigation(),
+		menus: NewMenuRegistry("main"),
+	}
+
+	// Все меню описываются один раз, битые ссылки ловим при старте
+	if err := ub.menus.Register(ultraMenus()...); err != nil {
+		return nil, err
+	}
+	if err := ub.menus.Validate(); err != nil {
+		return nil, err
 	}
 
 	ub.setupHandlers()
@@ -90,121 +179,68 @@ func (ub *UltraBot) setupHandlers() {
 }
 
 func (ub *UltraBot) handleStart(c tele.Context) error {
-	return ub.showMainMenu(c)
+	return ub.menus.Show(c, ub.nav, Screen{Scope: ScopeFromContext(c), MenuID: "main"})
 }
 
 func (ub *UltraBot) handleCallback(c tele.Context) error {
-	data := c.Callback().Data
-
-	// Проверяем кнопку "назад"
-	if isBack, returnTo := ub.nav.IsBackButton(data); isBack {
-		return ub.showMenu(c, returnTo)
-	}
-
-	// Проверяем кнопку меню
-	if isMenu, menuID := ub.nav.IsMenuButton(data); isMenu {
-		return ub.showMenu(c, menuID)
-	}
-
-	return c.Respond()
-}
-
-func (ub *UltraBot) showMenu(c tele.Context, menuID string) error {
-	switch menuID {
-	case "main":
-		return ub.showMainMenu(c)
-	case "channels":
-		return ub.showChannelsMenu(c)
-	case "settings":
-		return ub.showSettingsMenu(c)
-	case "add_channel":
-		return ub.showAddChannelMenu(c)
-	case "language":
-		return ub.showLanguageMenu(c)
-	default:
-		return c.Edit("Неизвестное меню")
+	// "back_to:" и "goto:" разбирает стратегия, экран рисует реестр
+	return ub.menus.Dispatch(c, ub.nav)
+}
+
+// ultraMenus описывает все экраны бота
+// Кнопка "назад" строится по Parent - каждая кнопка знает куда она ведет назад
+func ultraMenus() []*Menu {
+	return []*Menu{
+		{
+			ID:   "main",
+			Text: StaticText("🏠 <b>Главное меню</b>\n\nВыберите раздел:"),
+			Buttons: [][]MenuItem{
+				{{Text: "📊 Каналы", Target: "channels"}},
+				{{Text: "⚙️ Настройки", Target: "settings"}},
+			},
+		},
+		{
+			ID:     "channels",
+			Parent: "main",
+			Text:   StaticText("📊 <b>Управление каналами</b>\n\nВыберите действие:"),
+			Buttons: [][]MenuItem{
+				{{Text: "➕ Добавить", Target: "add_channel"}, {Text: "📋 Список", Target: "list_channels"}},
+			},
+		},
+		{
+			ID:     "list_channels",
+			Parent: "channels",
+			Text:   StaticText("📋 <b>Список каналов</b>\n\nКаналы еще не добавлены"),
+		},
+		{
+			ID:     "settings",
+			Parent: "main",
+			Text:   StaticText("⚙️ <b>Настройки</b>\n\nВыберите параметр:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🌐 Язык", Target: "language"}, {Text: "🔔 Уведомления", Target: "notifications"}},
+			},
+		},
+		{
+			ID:     "add_channel",
+			Parent: "channels",
+			Text:   StaticText("➕ <b>Добавление канала</b>\n\nВыберите способ:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🔗 По ссылке", Data: "add_by_link"}},
+				{{Text: "👤 По username", Data: "add_by_username"}},
+			},
+		},
+		{
+			ID:     "language",
+			Parent: "settings",
+			Text:   StaticText("🌐 <b>Выбор языка</b>\n\nВыберите язык:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🇷🇺 Русский", Data: "set_lang_ru"}, {Text: "🇺🇸 English", Data: "set_lang_en"}},
+			},
+		},
+		{
+			ID:     "notifications",
+			Parent: "settings",
+			Text:   StaticText("🔔 <b>Уведомления</b>\n\nНастройки уведомлений появятся позже"),
+		},
 	}
 }
-
-func (ub *UltraBot) showMainMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnChannels := ub.nav.CreateMenuButton("📊 Каналы", "channels")
-	btnSettings := ub.nav.CreateMenuButton("⚙️ Настройки", "settings")
-
-	selector.Inline(
-		selector.Row(*btnChannels),
-		selector.Row(*btnSettings),
-	)
-
-	text := "🏠 <b>Главное меню</b>\n\nВыберите раздел:"
-	return c.Edit(text, selector, tele.ModeHTML)
-}
-
-func (ub *UltraBot) showChannelsMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnAdd := ub.nav.CreateMenuButton("➕ Добавить", "add_channel")
-	btnList := ub.nav.CreateMenuButton("📋 Список", "list_channels")
-
-	selector.Inline(
-		selector.Row(*btnAdd, *btnList),
-	)
-
-	// Говорим кнопке "назад" куда возвращаться
-	ub.nav.AddBackButton(selector, "main")
-
-	text := "📊 <b>Управление каналами</b>\n\nВыберите действие:"
-	return c.Edit(text, selector, tele.ModeHTML)
-}
-
-func (ub *UltraBot) showSettingsMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnLang := ub.nav.CreateMenuButton("🌐 Язык", "language")
-	btnNotif := ub.nav.CreateMenuButton("🔔 Уведомления", "notifications")
-
-	selector.Inline(
-		selector.Row(*btnLang, *btnNotif),
-	)
-
-	ub.nav.AddBackButton(selector, "main")
-
-	text := "⚙️ <b>Настройки</b>\n\nВыберите параметр:"
-	return c.Edit(text, selector, tele.ModeHTML)
-}
-
-func (ub *UltraBot) showAddChannelMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btn1 := selector.Data("🔗 По ссылке", "add_by_link")
-	btn2 := selector.Data("👤 По username", "add_by_username")
-
-	selector.Inline(
-		selector.Row(*btn1),
-		selector.Row(*btn2),
-	)
-
-	// Возвращаемся в меню каналов
-	ub.nav.AddBackButton(selector, "channels")
-
-	text := "➕ <b>Добавление канала</b>\n\nВыберите способ:"
-	return c.Edit(text, selector, tele.ModeHTML)
-}
-
-func (ub *UltraBot) showLanguageMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btn1 := selector.Data("🇷🇺 Русский", "set_lang_ru")
-	btn2 := selector.Data("🇺🇸 English", "set_lang_en")
-
-	selector.Inline(
-		selector.Row(*btn1, *btn2),
-	)
-
-	// Возвращаемся в настройки
-	ub.nav.AddBackButton(selector, "settings")
-
-	text := "🌐 <b>Выбор языка</b>\n\nВыберите язык:"
-	return c.Edit(text, selector, tele.ModeHTML)
-}
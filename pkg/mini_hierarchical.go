@@ -2,34 +2,70 @@ package main
 
 import (
 	"fmt"
+	"log"
 	"strings"
+	"sync"
 	"time"
 
 	tele "gopkg.in/telebot.v3"
 )
 
+// hierarchyBackUnique - кнопка "назад": "nav_back|<menu_id>[|~путь]" в обертке версии callback_data
+// ID меню, на котором она нажата, и пройденный путь, если меню открыто не через основного родителя.
+// Кнопки без обертки из старых сообщений приходят в обработчик GetBackButton.
+const hierarchyBackUnique = "nav_back"
+
 // HierarchicalNavigation - навигация на основе статичной иерархии меню
-// Каждое меню знает своего родителя, как в файловой системе
+// Каждое меню знает своего родителя, как в файловой системе. Меню может быть доступно
+// и из других разделов (AddParent) - тогда "назад" ведет туда, откуда пользователь пришел.
 type HierarchicalNavigation struct {
-	hierarchy map[string]string // menu_id -> parent_id
-	backBtn   *tele.Btn
+	hierarchy    map[string]string   // menu_id -> основной parent_id
+	extraParents map[string][]string // menu_id -> дополнительные родители
+	codec        *PathCodec          // Кодек путей по всем родителям, пересобирается при изменении иерархии
+	root         string              // Корень иерархии, до него строятся пути и хлебные крошки
+	backBtn      *tele.Btn
+	mutex        sync.RWMutex     // Иерархия может подменяться при перезагрузке меню
+	envelope     callbackEnvelope // Версия callback_data кнопок меню
 }
 
 func NewHierarchicalNavigation() *HierarchicalNavigation {
 	selector := &tele.ReplyMarkup{}
-	backBtn := selector.Data("⬅️ Назад", "nav_back")
+	backBtn := selector.Data("⬅️ Назад", hierarchyBackUnique)
 
 	hn := &HierarchicalNavigation{
-		hierarchy: make(map[string]string),
-		backBtn:   backBtn,
+		hierarchy:    make(map[string]string),
+		extraParents: make(map[string][]string),
+		root:         hierarchyRoot,
+		backBtn:      &backBtn,
+		envelope:     newCallbackEnvelope(),
 	}
 
 	// Определяем иерархию меню один раз
 	hn.defineMenuHierarchy()
+	hn.rebuildCodec()
+
+	return hn
+}
+
+// NewHierarchicalNavigationFromDefinition создает навигацию по иерархии из файла описания меню
+func NewHierarchicalNavigationFromDefinition(md *MenuDefinition) *HierarchicalNavigation {
+	selector := &tele.ReplyMarkup{}
+	backBtn := selector.Data("⬅️ Назад", hierarchyBackUnique)
+
+	hn := &HierarchicalNavigation{
+		backBtn:  &backBtn,
+		envelope: newCallbackEnvelope(),
+	}
+	hn.ReplaceDefinition(md)
 
 	return hn
 }
 
+// SetCallbackVersions задает версионирование callback_data и перенаправления меню
+func (hn *HierarchicalNavigation) SetCallbackVersions(versions *CallbackVersions) {
+	hn.envelope.versions = versions
+}
+
 // defineMenuHierarchy определяет статичную структуру меню
 func (hn *HierarchicalNavigation) defineMenuHierarchy() {
 	// Просто карта: текущее_меню -> родительское_меню
@@ -67,6 +103,7 @@ func (hn *HierarchicalNavigation) defineMenuHierarchy() {
 		// Глубокая вложенность (пример)
 		"lang_russian":   "language",
 		"lang_english":   "language",
+		"lang_german":    "language",
 		"notif_channels": "notifications",
 		"notif_stats":    "notifications",
 		"theme_dark":     "theme",
@@ -78,26 +115,285 @@ func (hn *HierarchicalNavigation) defineMenuHierarchy() {
 		"notif_stats_daily":     "notif_stats",
 		"notif_stats_weekly":    "notif_stats",
 	}
+
+	// Меню, доступные из нескольких разделов: menu_id -> дополнительные родители
+	hn.extraParents = map[string][]string{
+		"channel_stats": {"stats"},
+	}
 }
 
 // RegisterMenu регистрирует новое меню с родителем
 func (hn *HierarchicalNavigation) RegisterMenu(menuID, parentID string) {
+	hn.mutex.Lock()
+	defer hn.mutex.Unlock()
+
 	hn.hierarchy[menuID] = parentID
+	hn.rebuildCodec()
+}
+
+// AddParent делает меню доступным еще из одного раздела
+// Основной родитель (RegisterMenu) остается целью "назад", когда путь пользователя неизвестен.
+// Если основного родителя еще нет, parentID становится им.
+func (hn *HierarchicalNavigation) AddParent(menuID, parentID string) {
+	hn.mutex.Lock()
+	defer hn.mutex.Unlock()
+
+	primary, exists := hn.hierarchy[menuID]
+	if !exists {
+		hn.hierarchy[menuID] = parentID
+		hn.rebuildCodec()
+		return
+	}
+	if primary == parentID {
+		return
+	}
+	for _, existing := range hn.extraParents[menuID] {
+		if existing == parentID {
+			return
+		}
+	}
+
+	hn.extraParents[menuID] = append(hn.extraParents[menuID], parentID)
+	hn.rebuildCodec()
+}
+
+// rebuildCodec пересобирает кодек путей, вызывается под блокировкой записи
+func (hn *HierarchicalNavigation) rebuildCodec() {
+	hn.codec = NewPathCodecFromParents(hn.parentsLocked())
+}
+
+// parentsLocked возвращает всех родителей каждого меню, основной - первым
+func (hn *HierarchicalNavigation) parentsLocked() map[string][]string {
+	parents := make(map[string][]string, len(hn.hierarchy))
+	for menuID, parentID := range hn.hierarchy {
+		parents[menuID] = append([]string{parentID}, hn.extraParents[menuID]...)
+	}
+	return parents
+}
+
+// ReplaceHierarchy атомарно подменяет иерархию целиком
+// Связи, которых нет в новой иерархии, удаляются: кнопка "назад" удаленного меню ведет в корень.
+// Дополнительные родители тоже сбрасываются, иерархию с ними задает ReplaceParents.
+func (hn *HierarchicalNavigation) ReplaceHierarchy(hierarchy map[string]string) {
+	parents := make(map[string][]string, len(hierarchy))
+	for menuID, parentID := range hierarchy {
+		parents[menuID] = []string{parentID}
+	}
+	hn.ReplaceParents(parents)
+}
+
+// ReplaceParents атомарно подменяет иерархию вместе с дополнительными родителями
+// menu_id -> [основной родитель, дополнительные...], как возвращает Parents
+func (hn *HierarchicalNavigation) ReplaceParents(parents map[string][]string) {
+	hn.mutex.Lock()
+	defer hn.mutex.Unlock()
+
+	hn.replaceParentsLocked(parents)
+}
+
+// ReplaceDefinition атомарно подменяет корень и иерархию на взятые из описания меню
+func (hn *HierarchicalNavigation) ReplaceDefinition(md *MenuDefinition) {
+	root := md.Root
+	if root == "" {
+		root = hierarchyRoot
+	}
+	parents := md.Parents()
+
+	hn.mutex.Lock()
+	defer hn.mutex.Unlock()
+
+	hn.root = root
+	hn.replaceParentsLocked(parents)
+}
+
+// replaceParentsLocked раскладывает родителей на основных и дополнительных, вызывается под блокировкой записи
+func (hn *HierarchicalNavigation) replaceParentsLocked(parents map[string][]string) {
+	hn.hierarchy = make(map[string]string, len(parents))
+	hn.extraParents = make(map[string][]string)
+	for menuID, ids := range parents {
+		if len(ids) == 0 {
+			continue
+		}
+		hn.hierarchy[menuID] = ids[0]
+		if len(ids) > 1 {
+			hn.extraParents[menuID] = append([]string{}, ids[1:]...)
+		}
+	}
+	hn.rebuildCodec()
+}
+
+// Follow подписывает иерархию на перезагрузки описания меню
+// Описание с битой иерархией отклоняется до подмены реестра, бот остается на предыдущем
+func (hn *HierarchicalNavigation) Follow(reloader *MenuReloader) {
+	reloader.OnValidate(func(md *MenuDefinition, menus *MenuRegistry) error {
+		report := NewHierarchicalNavigationFromDefinition(md).Validate(menus)
+		for _, warning := range report.Warnings() {
+			log.Printf("⚠️  Иерархия меню: %s", warning)
+		}
+		return report.Err()
+	})
+	reloader.OnReload(hn.ReplaceDefinition)
 }
 
 // GetParent возвращает родительское меню
 func (hn *HierarchicalNavigation) GetParent(menuID string) (string, bool) {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
 	parent, exists := hn.hierarchy[menuID]
 	return parent, exists
 }
 
-// GetBackButton возвращает универсальную кнопку "назад"
+// Hierarchy возвращает копию иерархии menu_id -> parent_id
+// Используется, например, для построения PathCodec
+func (hn *HierarchicalNavigation) Hierarchy() map[string]string {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
+	hierarchy := make(map[string]string, len(hn.hierarchy))
+	for menuID, parentID := range hn.hierarchy {
+		hierarchy[menuID] = parentID
+	}
+	return hierarchy
+}
+
+// Root возвращает корень иерархии
+func (hn *HierarchicalNavigation) Root() string {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
+	return hn.root
+}
+
+// SetRoot меняет корень иерархии (root: из описания меню), пустой корень - "main"
+func (hn *HierarchicalNavigation) SetRoot(root string) {
+	if root == "" {
+		root = hierarchyRoot
+	}
+
+	hn.mutex.Lock()
+	defer hn.mutex.Unlock()
+
+	hn.root = root
+}
+
+// Parents возвращает копию иерархии со всеми родителями menu_id -> [основной, дополнительные...]
+func (hn *HierarchicalNavigation) Parents() map[string][]string {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
+	return hn.parentsLocked()
+}
+
+// isParent проверяет, что parentID - основной или дополнительный родитель меню
+func (hn *HierarchicalNavigation) isParent(menuID, parentID string) bool {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
+	if primary, exists := hn.hierarchy[menuID]; !exists || primary == parentID {
+		return exists
+	}
+	for _, extra := range hn.extraParents[menuID] {
+		if extra == parentID {
+			return true
+		}
+	}
+	return false
+}
+
+// validPath проверяет, что путь идет от корня по связям иерархии
+// Путь из кнопки мог устареть после перезагрузки меню - тогда используется основной родитель
+func (hn *HierarchicalNavigation) validPath(path []string) bool {
+	if len(path) == 0 || path[0] != hn.Root() {
+		return false
+	}
+	for i := 1; i < len(path); i++ {
+		if !hn.isParent(path[i], path[i-1]) {
+			return false
+		}
+	}
+	return true
+}
+
+// encodePath кодирует путь для callback_data
+func (hn *HierarchicalNavigation) encodePath(path []string) string {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
+	return compactPathPrefix + hn.codec.Encode(path)
+}
+
+// decodePath восстанавливает путь из кнопки, ok=false - путь битый или устарел
+func (hn *HierarchicalNavigation) decodePath(encoded string) ([]string, bool) {
+	hn.mutex.RLock()
+	path, err := hn.codec.Decode(strings.TrimPrefix(encoded, compactPathPrefix))
+	hn.mutex.RUnlock()
+
+	if err != nil {
+		return nil, false
+	}
+	for i, menuID := range path {
+		path[i] = hn.envelope.migrateMenu(menuID)
+	}
+	return path, hn.validPath(path)
+}
+
+// GetBackButton возвращает универсальную кнопку "назад" для регистрации обработчика
+// На экранах используется CreateBackButton с ID текущего меню
 func (hn *HierarchicalNavigation) GetBackButton() *tele.Btn {
 	return hn.backBtn
 }
 
+// CreateBackButton создает кнопку "назад" с ID меню, на котором она показана: "v2:nav_back|<menu_id>"
+// Текущее меню берется из кнопки, а не угадывается по тексту сообщения.
+// Слишком длинный ID не влезает в 64 байта - тогда кнопка без ID ведет в корень.
+func (hn *HierarchicalNavigation) CreateBackButton(menuID string) *tele.Btn {
+	payload := hierarchyBackUnique + "|" + menuID
+	if len(payload) > hn.envelope.callbackLimit() {
+		payload = hierarchyBackUnique
+	}
+
+	selector := &tele.ReplyMarkup{}
+	btn := selector.Data(hn.backBtn.Text, hn.envelope.wrap(payload))
+	return &btn
+}
+
+// BackFrom возвращает экран родителя меню, с которого нажата кнопка "назад"
+// В данных кнопки - ID меню и, если меню открыто не через основного родителя, пройденный путь:
+// "<menu_id>|~<путь>", тогда родитель берется из пути. Устаревший путь ведет к основному родителю.
+// Пустой ID - кнопка из сообщения, отправленного до появления ID в кнопке, ведет в корень
+func (hn *HierarchicalNavigation) BackFrom(scope NavigationScope, data string) (Screen, bool) {
+	menuID, encodedPath, _ := strings.Cut(data, "|")
+	if strings.HasPrefix(menuID, compactPathPrefix) {
+		// Кнопка до появления ID меню рядом с путем: "~<путь>"
+		menuID, encodedPath = "", menuID
+	}
+	menuID = hn.envelope.migrateMenu(menuID)
+
+	if encodedPath != "" {
+		path, ok := hn.decodePath(encodedPath)
+		if ok && (menuID == "" || path[len(path)-1] == menuID) {
+			parent, hasParent, _ := hn.Back(Screen{Scope: scope, MenuID: path[len(path)-1], Path: path})
+			return parent, hasParent
+		}
+		if menuID == "" && len(path) > 0 {
+			menuID = path[len(path)-1]
+		}
+		// Путь устарел - возвращаемся к основному родителю меню
+	}
+	if menuID == "" {
+		return Screen{Scope: scope, MenuID: hn.Root()}, true
+	}
+
+	parent, ok, _ := hn.Back(Screen{Scope: scope, MenuID: menuID})
+	return parent, ok
+}
+
 // HasParent проверяет, есть ли у меню родитель
 func (hn *HierarchicalNavigation) HasParent(menuID string) bool {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
 	_, exists := hn.hierarchy[menuID]
 	return exists
 }
@@ -108,19 +404,22 @@ func (hn *HierarchicalNavigation) AddBackButton(keyboard *tele.ReplyMarkup, curr
 		return // Нет родителя - нет кнопки
 	}
 
-	if keyboard.InlineKeyboard == nil {
-		keyboard.InlineKeyboard = make([][]tele.Btn, 0)
-	}
-	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tele.Btn{*hn.backBtn})
+	appendButtonRow(keyboard, hn.CreateBackButton(currentMenu))
 }
 
 // GetBreadcrumb возвращает путь до корня (для отладки/показа пути)
+// Если родители замкнуты в кольцо, путь обрывается на повторе (см. Validate)
 func (hn *HierarchicalNavigation) GetBreadcrumb(menuID string) []string {
+	hn.mutex.RLock()
+	defer hn.mutex.RUnlock()
+
 	var path []string
 	current := menuID
+	seen := make(map[string]bool)
 
 	// Идем вверх по иерархии до корня
-	for current != "" && current != "main" {
+	for current != "" && current != hn.root && !seen[current] {
+		seen[current] = true
 		path = append([]string{current}, path...) // Добавляем в начало
 		parent, exists := hn.hierarchy[current]
 		if !exists {
@@ -129,9 +428,9 @@ func (hn *HierarchicalNavigation) GetBreadcrumb(menuID string) []string {
 		current = parent
 	}
 
-	// Добавляем main в начало
+	// Добавляем корень в начало
 	if len(path) > 0 {
-		path = append([]string{"main"}, path...)
+		path = append([]string{hn.root}, path...)
 	}
 
 	return path
@@ -140,249 +439,325 @@ func (hn *HierarchicalNavigation) GetBreadcrumb(menuID string) []string {
 // CreateMenuButton создает кнопку для перехода в меню
 func (hn *HierarchicalNavigation) CreateMenuButton(text, menuID string) *tele.Btn {
 	selector := &tele.ReplyMarkup{}
-	return selector.Data(text, "menu:"+menuID)
+	btn := selector.Data(text, hn.envelope.wrap("menu:"+menuID))
+	return &btn
 }
 
-// Пример использования
-type SimpleBot struct {
-	*tele.Bot
-	nav *HierarchicalNavigation
-}
-
-func NewSimpleBot(token string) (*SimpleBot, error) {
-	bot, err := tele.NewBot(tele.Settings{
-		Token:  token,
-		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
-	})
-	if err != nil {
-		return nil, err
+// MenuButton реализует Navigator: кнопка перехода в меню
+// Если путь через from не совпадает с путем по основным родителям, кнопка несет путь from:
+// "menu:<menu_id>:~<путь>". Слишком длинный путь не влезает в 64 байта - тогда кнопка обычная.
+func (hn *HierarchicalNavigation) MenuButton(from Screen, text, menuID string) *tele.Btn {
+	if len(from.Path) == 0 || samePath(append(append([]string{}, from.Path...), menuID), hn.GetBreadcrumb(menuID)) {
+		return hn.CreateMenuButton(text, menuID)
 	}
 
-	nav := NewHierarchicalNavigation()
-
-	sb := &SimpleBot{
-		Bot: bot,
-		nav: nav,
+	callbackData := "menu:" + menuID + ":" + hn.encodePath(from.Path)
+	if len(callbackData) > hn.envelope.callbackLimit() {
+		return hn.CreateMenuButton(text, menuID)
 	}
 
-	sb.setupHandlers()
-	return sb, nil
-}
-
-func (sb *SimpleBot) setupHandlers() {
-	// Универсальный обработчик кнопки "назад"
-	sb.Handle(sb.nav.GetBackButton(), sb.handleBack)
-
-	// Команды
-	sb.Handle("/start", sb.handleStart)
-
-	// Обработчик всех меню
-	sb.Handle(tele.OnCallback, sb.handleCallback)
+	selector := &tele.ReplyMarkup{}
+	btn := selector.Data(text, hn.envelope.wrap(callbackData))
+	return &btn
 }
 
-// handleBack - СУПЕР ПРОСТОЙ обработчик кнопки "назад"
-func (sb *SimpleBot) handleBack(c tele.Context) error {
-	// Получаем текущее меню из callback query (или из context)
-	currentMenu := sb.getCurrentMenu(c)
-
-	// Просто ищем родителя в статичной карте
-	parentMenu, hasParent := sb.nav.GetParent(currentMenu)
+// BackButton реализует Navigator: кнопка с ID меню, если у меню есть родитель
+// Экран, открытый не через основного родителя, получает кнопку еще и с пройденным путем
+// Если путь устареет после перезагрузки меню, ID ведет к основному родителю
+func (hn *HierarchicalNavigation) BackButton(screen Screen) *tele.Btn {
+	if !hn.HasParent(screen.MenuID) {
+		return nil
+	}
+	if len(screen.Path) < 2 || samePath(screen.Path, hn.GetBreadcrumb(screen.MenuID)) {
+		return hn.CreateBackButton(screen.MenuID)
+	}
 
-	if !hasParent {
-		return c.Respond(&tele.CallbackResponse{
-			Text: "Вы уже в главном меню",
-		})
+	payload := hierarchyBackUnique + "|" + screen.MenuID + "|" + hn.encodePath(screen.Path)
+	if len(payload) > hn.envelope.callbackLimit() {
+		return hn.CreateBackButton(screen.MenuID)
 	}
 
-	// Переходим к родительскому меню
-	return sb.showMenu(c, parentMenu)
+	selector := &tele.ReplyMarkup{}
+	btn := selector.Data(hn.backBtn.Text, hn.envelope.wrap(payload))
+	return &btn
 }
 
-// getCurrentMenu определяет текущее меню из контекста
-func (sb *SimpleBot) getCurrentMenu(c tele.Context) string {
-	// Можно извлечь из callback data предыдущей кнопки
-	// Или из message, или хранить в user_data
-	// Для простоты будем извлекать из текста сообщения
-
-	text := c.Message().Text
-	if text == "" && c.Callback() != nil {
-		text = c.Callback().Message.Text
+// samePath сравнивает два пути поэлементно
+func samePath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
 	}
-
-	// Простое определение по тексту (можно улучшить)
-	switch {
-	case strings.Contains(text, "Каналы"):
-		return "channels"
-	case strings.Contains(text, "Статистика"):
-		return "stats"
-	case strings.Contains(text, "Настройки"):
-		return "settings"
-	case strings.Contains(text, "Профиль"):
-		return "profile"
-	case strings.Contains(text, "Добавить канал"):
-		return "add_channel"
-	case strings.Contains(text, "Уведомления"):
-		return "notifications"
-	case strings.Contains(text, "Язык"):
-		return "language"
-	case strings.Contains(text, "Тема"):
-		return "theme"
-	default:
-		return "main"
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
 	}
+	return true
 }
 
-func (sb *SimpleBot) handleStart(c tele.Context) error {
-	return sb.showMenu(c, "main")
+// Open реализует Navigator: иерархия статична, запоминать нечего
+func (hn *HierarchicalNavigation) Open(screen Screen) error {
+	return nil
 }
 
-func (sb *SimpleBot) handleCallback(c tele.Context) error {
-	data := c.Callback().Data
-
-	// Пропускаем кнопку "назад" - она обрабатывается отдельно
-	if data == "nav_back" {
-		return nil
+// Back реализует Navigator: переходим к родителю из пути экрана, если путь сходится с иерархией,
+// иначе - к основному родителю
+func (hn *HierarchicalNavigation) Back(screen Screen) (Screen, bool, error) {
+	if n := len(screen.Path); n >= 2 && screen.Path[n-1] == screen.MenuID && hn.validPath(screen.Path) {
+		return Screen{
+			Scope:  screen.Scope,
+			MenuID: screen.Path[n-2],
+			Path:   append([]string{}, screen.Path[:n-1]...),
+		}, true, nil
 	}
 
-	// Обрабатываем кнопки меню
-	if strings.HasPrefix(data, "menu:") {
-		menuID := strings.TrimPrefix(data, "menu:")
-		return sb.showMenu(c, menuID)
+	parent, exists := hn.GetParent(screen.MenuID)
+	if !exists {
+		return Screen{}, false, nil
 	}
 
-	return c.Respond()
+	return Screen{
+		Scope:  screen.Scope,
+		MenuID: parent,
+		Path:   hn.GetBreadcrumb(parent),
+	}, true, nil
 }
 
-func (sb *SimpleBot) showMenu(c tele.Context, menuID string) error {
-	switch menuID {
-	case "main":
-		return sb.showMainMenu(c)
-	case "channels":
-		return sb.showChannelsMenu(c)
-	case "stats":
-		return sb.showStatsMenu(c)
-	case "settings":
-		return sb.showSettingsMenu(c)
-	case "add_channel":
-		return sb.showAddChannelMenu(c)
-	case "notifications":
-		return sb.showNotificationsMenu(c)
-	case "language":
-		return sb.showLanguageMenu(c)
-	default:
-		return c.Edit("Неизвестное меню: " + menuID)
-	}
-}
+// Resolve реализует Navigator: разбирает "menu:<menu_id>[:~<путь>]" и "nav_back|<menu_id>[|~путь]"
+func (hn *HierarchicalNavigation) Resolve(scope NavigationScope, callbackData string) (Screen, bool, error) {
+	data := normalizeCallbackData(callbackData)
 
-func (sb *SimpleBot) showMainMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
+	if unique, _, _ := strings.Cut(hn.envelope.peek(data), "|"); unique == hierarchyBackUnique {
+		payload, err := hn.envelope.unwrap(data)
+		if err != nil {
+			return Screen{}, true, err
+		}
+		_, backData, _ := strings.Cut(payload, "|")
+		parent, ok := hn.BackFrom(scope, backData)
+		if !ok {
+			// У меню нет родителя - остаемся в корне
+			return Screen{Scope: scope, MenuID: hn.Root()}, true, nil
+		}
+		return parent, true, nil
+	}
 
-	btnChannels := sb.nav.CreateMenuButton("📊 Каналы", "channels")
-	btnStats := sb.nav.CreateMenuButton("📈 Статистика", "stats")
-	btnSettings := sb.nav.CreateMenuButton("⚙️ Настройки", "settings")
+	if strings.HasPrefix(hn.envelope.peek(data), "menu:") {
+		payload, err := hn.envelope.unwrap(data)
+		if err != nil {
+			return Screen{}, true, err
+		}
+		menuID, encodedPath, _ := strings.Cut(strings.TrimPrefix(payload, "menu:"), ":")
+		menuID = hn.envelope.migrateMenu(menuID)
 
-	selector.Inline(
-		selector.Row(*btnChannels),
-		selector.Row(*btnStats, *btnSettings),
-	)
+		// Путь, которым пришел пользователь, если он ведет в меню по связям иерархии
+		if from, ok := hn.decodePath(encodedPath); ok && encodedPath != "" && hn.isParent(menuID, from[len(from)-1]) {
+			return Screen{Scope: scope, MenuID: menuID, Path: append(from, menuID)}, true, nil
+		}
+		return Screen{Scope: scope, MenuID: menuID, Path: hn.GetBreadcrumb(menuID)}, true, nil
+	}
 
-	// НЕ добавляем кнопку "назад" в главное меню
+	return Screen{}, false, nil
+}
 
-	text := "🏠 <b>Главное меню</b>\n\nВыберите раздел:"
-	return c.Edit(text, selector, tele.ModeHTML)
+// Пример использования
+type SimpleBot struct {
+	*tele.Bot
+	nav   *HierarchicalNavigation
+	menus MenuSource
 }
 
-func (sb *SimpleBot) showChannelsMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
+func NewSimpleBot(token string) (*SimpleBot, error) {
+	bot, err := tele.NewBot(tele.Settings{
+		Token:  token,
+		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		return nil, err
+	}
 
-	btnAdd := sb.nav.CreateMenuButton("➕ Добавить канал", "add_channel")
-	btnList := sb.nav.CreateMenuButton("📋 Список каналов", "list_channels")
-	btnStats := sb.nav.CreateMenuButton("📊 Статистика каналов", "channel_stats")
+	nav := NewHierarchicalNavigation()
 
-	selector.Inline(
-		selector.Row(*btnAdd),
-		selector.Row(*btnList, *btnStats),
-	)
+	// Все меню описываются один раз, битые ссылки ловим при старте
+	menus := NewMenuRegistry("main")
+	if err := menus.Register(simpleMenus()...); err != nil {
+		return nil, err
+	}
+	if err := menus.Validate(); err != nil {
+		return nil, err
+	}
 
-	// Автоматически добавляем кнопку "назад"
-	sb.nav.AddBackButton(selector, "channels")
+	// Иерархия должна сходиться к корню и совпадать с реестром
+	report := nav.Validate(menus)
+	if err := report.Err(); err != nil {
+		return nil, err
+	}
+	for _, warning := range report.Warnings() {
+		log.Printf("⚠️  Иерархия меню: %s", warning)
+	}
 
-	breadcrumb := sb.nav.GetBreadcrumb("channels")
-	text := fmt.Sprintf("📊 <b>Управление каналами</b>\n\n📍 Путь: %v\n\nВыберите действие:", breadcrumb)
+	sb := &SimpleBot{
+		Bot:   bot,
+		nav:   nav,
+		menus: menus,
+	}
 
-	return c.Edit(text, selector, tele.ModeHTML)
+	sb.setupHandlers()
+	return sb, nil
 }
 
-func (sb *SimpleBot) showSettingsMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnLang := sb.nav.CreateMenuButton("🌐 Язык", "language")
-	btnNotif := sb.nav.CreateMenuButton("🔔 Уведомления", "notifications")
-	btnTheme := sb.nav.CreateMenuButton("🎨 Тема", "theme")
+// NewSimpleBotFromFile создает бота с меню из файла описания
+// Изменения файла подхватываются на лету, reloader нужно закрыть при остановке бота
+func NewSimpleBotFromFile(token, path string) (*SimpleBot, *MenuReloader, error) {
+	bot, err := tele.NewBot(tele.Settings{
+		Token:  token,
+		Poller: &tele.LongPoller{Timeout: 10 * time.Second},
+	})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	selector.Inline(
-		selector.Row(*btnLang, *btnNotif),
-		selector.Row(*btnTheme),
-	)
+	reloader := NewMenuReloader(path, 5*time.Second)
+	// Иерархия заполняется при первой загрузке и обновляется вместе с реестром
+	nav := NewHierarchicalNavigationFromDefinition(&MenuDefinition{})
+	nav.Follow(reloader)
+	if err := reloader.Start(); err != nil {
+		return nil, nil, err
+	}
 
-	sb.nav.AddBackButton(selector, "settings")
+	sb := &SimpleBot{
+		Bot:   bot,
+		nav:   nav,
+		menus: reloader,
+	}
 
-	text := "⚙️ <b>Настройки</b>\n\nВыберите параметр:"
-	return c.Edit(text, selector, tele.ModeHTML)
+	sb.setupHandlers()
+	return sb, reloader, nil
 }
 
-func (sb *SimpleBot) showAddChannelMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnByLink := selector.Data("🔗 По ссылке", "add_by_link")
-	btnByUsername := selector.Data("👤 По username", "add_by_username")
-
-	selector.Inline(
-		selector.Row(*btnByLink),
-		selector.Row(*btnByUsername),
-	)
-
-	sb.nav.AddBackButton(selector, "add_channel")
+func (sb *SimpleBot) setupHandlers() {
+	// Универсальный обработчик кнопки "назад"
+	sb.Handle(sb.nav.GetBackButton(), sb.handleBack)
 
-	breadcrumb := sb.nav.GetBreadcrumb("add_channel")
-	text := fmt.Sprintf("➕ <b>Добавление канала</b>\n\n📍 Путь: %v\n\nВыберите способ:", breadcrumb)
+	// Команды
+	sb.Handle("/start", sb.handleStart)
 
-	return c.Edit(text, selector, tele.ModeHTML)
+	// Обработчик всех меню
+	sb.Handle(tele.OnCallback, sb.handleCallback)
 }
 
-func (sb *SimpleBot) showNotificationsMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnChannels := sb.nav.CreateMenuButton("📊 Уведомления о каналах", "notif_channels")
-	btnStats := sb.nav.CreateMenuButton("📈 Уведомления о статистике", "notif_stats")
-
-	selector.Inline(
-		selector.Row(*btnChannels),
-		selector.Row(*btnStats),
-	)
-
-	sb.nav.AddBackButton(selector, "notifications")
+// handleBack - СУПЕР ПРОСТОЙ обработчик кнопки "назад" из старых сообщений
+// Кнопки в обертке версии telebot не узнает по unique, их разбирает handleCallback
+func (sb *SimpleBot) handleBack(c tele.Context) error {
+	return sb.menus.View(func(menus *MenuRegistry) error {
+		// telebot уже отрезал "\fnav_back|" - в данных остался ID меню, на котором нажата кнопка, и путь
+		parent, hasParent := sb.nav.BackFrom(ScopeFromContext(c), c.Callback().Data)
+
+		if !hasParent {
+			return c.Respond(&tele.CallbackResponse{
+				Text: "Вы уже в главном меню",
+			})
+		}
 
-	text := "🔔 <b>Настройки уведомлений</b>\n\nВыберите тип:"
-	return c.Edit(text, selector, tele.ModeHTML)
+		// Переходим к родительскому меню
+		return menus.Show(c, sb.nav, parent)
+	})
 }
 
-func (sb *SimpleBot) showLanguageMenu(c tele.Context) error {
-	selector := &tele.ReplyMarkup{}
-
-	btnRu := sb.nav.CreateMenuButton("🇷🇺 Русский", "lang_russian")
-	btnEn := sb.nav.CreateMenuButton("🇺🇸 English", "lang_english")
-	btnDe := sb.nav.CreateMenuButton("🇩🇪 Deutsch", "lang_german")
+func (sb *SimpleBot) handleStart(c tele.Context) error {
+	return sb.menus.View(func(menus *MenuRegistry) error {
+		return menus.Show(c, sb.nav, Screen{Scope: ScopeFromContext(c), MenuID: menus.Root()})
+	})
+}
 
-	selector.Inline(
-		selector.Row(*btnRu, *btnEn),
-		selector.Row(*btnDe),
-	)
+func (sb *SimpleBot) handleCallback(c tele.Context) error {
+	// Кнопки "menu:" разбирает стратегия, экран рисует реестр
+	// Реестр и иерархия читаются вместе, перезагрузка файла ждет окончания обработки
+	return sb.menus.View(func(menus *MenuRegistry) error {
+		return menus.Dispatch(c, sb.nav)
+	})
+}
 
-	sb.nav.AddBackButton(selector, "language")
+// breadcrumbText рендерит текст экрана с путем до него
+func breadcrumbText(title, prompt string) func(Screen) string {
+	return func(screen Screen) string {
+		return fmt.Sprintf("%s\n\n📍 Путь: %v\n\n%s", title, screen.Path, prompt)
+	}
+}
 
-	breadcrumb := sb.nav.GetBreadcrumb("language")
-	text := fmt.Sprintf("🌐 <b>Выбор языка</b>\n\n📍 Путь: %v\n\nВыберите язык:", breadcrumb)
+// leafMenu описывает конечный экран без собственных кнопок
+func leafMenu(id, text string) *Menu {
+	return &Menu{ID: id, Text: StaticText(text)}
+}
 
-	return c.Edit(text, selector, tele.ModeHTML)
+// simpleMenus описывает все экраны бота
+// Кнопку "назад" добавляет иерархия, поэтому Parent не указывается
+func simpleMenus() []*Menu {
+	return []*Menu{
+		{
+			ID:   "main",
+			Text: StaticText("🏠 <b>Главное меню</b>\n\nВыберите раздел:"),
+			Buttons: [][]MenuItem{
+				{{Text: "📊 Каналы", Target: "channels"}},
+				{{Text: "📈 Статистика", Target: "stats"}, {Text: "⚙️ Настройки", Target: "settings"}},
+			},
+		},
+		{
+			ID:   "channels",
+			Text: breadcrumbText("📊 <b>Управление каналами</b>", "Выберите действие:"),
+			Buttons: [][]MenuItem{
+				{{Text: "➕ Добавить канал", Target: "add_channel"}},
+				{{Text: "📋 Список каналов", Target: "list_channels"}, {Text: "📊 Статистика каналов", Target: "channel_stats"}},
+			},
+		},
+		{
+			ID:   "add_channel",
+			Text: breadcrumbText("➕ <b>Добавление канала</b>", "Выберите способ:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🔗 По ссылке", Data: "add_by_link"}},
+				{{Text: "👤 По username", Data: "add_by_username"}},
+			},
+		},
+		leafMenu("list_channels", "📋 <b>Список каналов</b>\n\nКаналы еще не добавлены"),
+		leafMenu("channel_stats", "📊 <b>Статистика каналов</b>\n\nДанных пока нет"),
+		{
+			ID:   "stats",
+			Text: breadcrumbText("📈 <b>Статистика</b>", "Выберите период:"),
+			Buttons: [][]MenuItem{
+				{{Text: "📅 За день", Target: "daily_stats"}, {Text: "🗓 За неделю", Target: "weekly_stats"}},
+				{{Text: "📊 Статистика каналов", Target: "channel_stats"}},
+			},
+		},
+		leafMenu("daily_stats", "📅 <b>Статистика за день</b>\n\nДанных пока нет"),
+		leafMenu("weekly_stats", "🗓 <b>Статистика за неделю</b>\n\nДанных пока нет"),
+		{
+			ID:   "settings",
+			Text: StaticText("⚙️ <b>Настройки</b>\n\nВыберите параметр:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🌐 Язык", Target: "language"}, {Text: "🔔 Уведомления", Target: "notifications"}},
+				{{Text: "🎨 Тема", Target: "theme"}},
+			},
+		},
+		{
+			ID:   "notifications",
+			Text: StaticText("🔔 <b>Настройки уведомлений</b>\n\nВыберите тип:"),
+			Buttons: [][]MenuItem{
+				{{Text: "📊 Уведомления о каналах", Target: "notif_channels"}},
+				{{Text: "📈 Уведомления о статистике", Target: "notif_stats"}},
+			},
+		},
+		leafMenu("notif_channels", "📊 <b>Уведомления о каналах</b>\n\nНастройки появятся позже"),
+		leafMenu("notif_stats", "📈 <b>Уведомления о статистике</b>\n\nНастройки появятся позже"),
+		{
+			ID:   "language",
+			Text: breadcrumbText("🌐 <b>Выбор языка</b>", "Выберите язык:"),
+			Buttons: [][]MenuItem{
+				{{Text: "🇷🇺 Русский", Target: "lang_russian"}, {Text: "🇺🇸 English", Target: "lang_english"}},
+				{{Text: "🇩🇪 Deutsch", Target: "lang_german"}},
+			},
+		},
+		leafMenu("lang_russian", "🇷🇺 Язык интерфейса: <b>Русский</b>"),
+		leafMenu("lang_english", "🇺🇸 Interface language: <b>English</b>"),
+		leafMenu("lang_german", "🇩🇪 Sprache der Oberfläche: <b>Deutsch</b>"),
+		leafMenu("theme", "🎨 <b>Тема</b>\n\nВыбор темы появится позже"),
+	}
 }
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// navigationCache - LRU кэш стеков навигации с TTL
+// Размер ограничивается сразу при вставке: вытесняется самый давно использованный
+// пользователь, поэтому активные пользователи не уходят в БД на каждом клике.
+type navigationCache struct {
+	capacity int
+	ttl      time.Duration
+	entries  map[NavigationScope]*list.Element
+	order    *list.List // Начало списка - недавно использованные стеки
+	mutex    sync.Mutex
+
+	hits      int64
+	misses    int64
+	evictions int64
+	expired   int64
+}
+
+type navigationCacheEntry struct {
+	scope     NavigationScope
+	record    NavigationRecord
+	expiresAt time.Time
+}
+
+func newNavigationCache(capacity int, ttl time.Duration) *navigationCache {
+	return &navigationCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[NavigationScope]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Get возвращает стек пользователя и отмечает его как недавно использованный
+// Запись с истекшим TTL удаляется и считается промахом
+func (nc *navigationCache) Get(scope NavigationScope) (NavigationRecord, bool) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	elem, exists := nc.entries[scope]
+	if !exists {
+		nc.misses++
+		return NavigationRecord{}, false
+	}
+
+	entry := elem.Value.(*navigationCacheEntry)
+	if time.Now().After(entry.expiresAt) {
+		nc.removeElement(elem)
+		nc.expired++
+		nc.misses++
+		return NavigationRecord{}, false
+	}
+
+	nc.order.MoveToFront(elem)
+	nc.hits++
+	return entry.record, true
+}
+
+// Put сохраняет стек и продлевает TTL, при переполнении вытесняет самый старый стек
+func (nc *navigationCache) Put(scope NavigationScope, record NavigationRecord) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	expiresAt := time.Now().Add(nc.ttl)
+
+	if elem, exists := nc.entries[scope]; exists {
+		entry := elem.Value.(*navigationCacheEntry)
+		entry.record = record
+		entry.expiresAt = expiresAt
+		nc.order.MoveToFront(elem)
+		return
+	}
+
+	nc.entries[scope] = nc.order.PushFront(&navigationCacheEntry{
+		scope:     scope,
+		record:    record,
+		expiresAt: expiresAt,
+	})
+
+	for nc.order.Len() > nc.capacity {
+		nc.removeElement(nc.order.Back())
+		nc.evictions++
+	}
+}
+
+// SetVersion запоминает версию, записанную в хранилище, чтобы следующая запись не дала конфликт
+func (nc *navigationCache) SetVersion(scope NavigationScope, version int64) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if elem, exists := nc.entries[scope]; exists {
+		entry := elem.Value.(*navigationCacheEntry)
+		if version > entry.record.Version {
+			entry.record.Version = version
+			entry.record.Degraded = false // Стек записан - дальше это обычная запись хранилища
+		}
+	}
+}
+
+// Remove удаляет стек пользователя из кэша
+func (nc *navigationCache) Remove(scope NavigationScope) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	if elem, exists := nc.entries[scope]; exists {
+		nc.removeElement(elem)
+	}
+}
+
+// RemoveUser удаляет стеки пользователя во всех областях и возвращает их количество
+func (nc *navigationCache) RemoveUser(userID int64) int {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	var removed int
+	for scope, elem := range nc.entries {
+		if scope.UserID == userID {
+			nc.removeElement(elem)
+			removed++
+		}
+	}
+	return removed
+}
+
+// Clear удаляет все записи
+func (nc *navigationCache) Clear() {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	nc.entries = make(map[NavigationScope]*list.Element)
+	nc.order.Init()
+}
+
+// Expire удаляет все записи с истекшим TTL и возвращает их количество
+func (nc *navigationCache) Expire() int {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	now := time.Now()
+	var removed int
+
+	for elem := nc.order.Back(); elem != nil; {
+		prev := elem.Prev()
+		if now.After(elem.Value.(*navigationCacheEntry).expiresAt) {
+			nc.removeElement(elem)
+			removed++
+		}
+		elem = prev
+	}
+
+	nc.expired += int64(removed)
+	return removed
+}
+
+func (nc *navigationCache) removeElement(elem *list.Element) {
+	nc.order.Remove(elem)
+	delete(nc.entries, elem.Value.(*navigationCacheEntry).scope)
+}
+
+// Stats возвращает размер кэша и счетчики попаданий, промахов и вытеснений
+func (nc *navigationCache) Stats() map[string]interface{} {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+
+	var hitRate float64
+	if total := nc.hits + nc.misses; total > 0 {
+		hitRate = float64(nc.hits) / float64(total)
+	}
+
+	return map[string]interface{}{
+		"cache_size":        nc.order.Len(),
+		"max_cache_size":    nc.capacity,
+		"cache_timeout_min": nc.ttl.Minutes(),
+		"cache_hits":        nc.hits,
+		"cache_misses":      nc.misses,
+		"cache_hit_rate":    hitRate,
+		"cache_evictions":   nc.evictions,
+		"cache_expired":     nc.expired,
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNavigationCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := newNavigationCache(3, time.Minute)
+	for userID := int64(1); userID <= 3; userID++ {
+		cache.Put(UserScope(userID), NavigationRecord{Stack: []string{"main"}})
+	}
+
+	// Чтение и перезапись поднимают стек в начало очереди
+	if _, ok := cache.Get(UserScope(1)); !ok {
+		t.Fatal("user 1 is missing before eviction")
+	}
+	cache.Put(UserScope(2), NavigationRecord{Stack: []string{"main", "settings"}})
+
+	// Самый давно использованный - пользователь 3
+	cache.Put(UserScope(4), NavigationRecord{Stack: []string{"main"}})
+	if _, ok := cache.Get(UserScope(3)); ok {
+		t.Error("least recently used user 3 survived eviction")
+	}
+
+	// Теперь самый старый - пользователь 1
+	cache.Put(UserScope(5), NavigationRecord{Stack: []string{"main"}})
+	if _, ok := cache.Get(UserScope(1)); ok {
+		t.Error("user 1 survived the second eviction")
+	}
+
+	for _, userID := range []int64{2, 4, 5} {
+		if _, ok := cache.Get(UserScope(userID)); !ok {
+			t.Errorf("recently used user %d was evicted", userID)
+		}
+	}
+}
+
+func TestNavigationCacheBoundOnInsert(t *testing.T) {
+	cache := newNavigationCache(10, time.Minute)
+	for userID := int64(1); userID <= 25; userID++ {
+		cache.Put(UserScope(userID), NavigationRecord{Stack: []string{"main"}})
+
+		// Размер ограничивается сразу, а не фоновой очисткой
+		if size := cache.Stats()["cache_size"].(int); size > 10 {
+			t.Fatalf("cache holds %d stacks after inserting user %d, capacity 10", size, userID)
+		}
+	}
+
+	stats := cache.Stats()
+	if size := stats["cache_size"].(int); size != 10 {
+		t.Errorf("cache_size = %d, want 10", size)
+	}
+	if evictions := stats["cache_evictions"].(int64); evictions != 15 {
+		t.Errorf("cache_evictions = %d, want 15", evictions)
+	}
+}
+
+func TestNavigationStatsCacheCounters(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryNavigationStore()
+	for userID := int64(1); userID <= 3; userID++ {
+		if _, err := store.Save(ctx, UserScope(userID), NavigationRecord{Stack: []string{"main"}, ClickedAt: time.Now().UTC()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pnm := NewPersistentNavigationManagerWithStore(store)
+	t.Cleanup(func() { pnm.Close(context.Background()) })
+	pnm.cache = newNavigationCache(2, time.Minute)
+
+	load := func(userID int64) {
+		t.Helper()
+		if _, err := pnm.getStackFromCacheOrDB(ctx, UserScope(userID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	load(1) // Промах
+	load(2) // Промах
+	load(1) // Попадание, пользователь 1 становится недавно использованным
+	load(3) // Промах, вытесняет пользователя 2
+	load(2) // Промах, вытесняет пользователя 1
+	load(3) // Попадание
+
+	stats, err := pnm.GetNavigationStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]int64{
+		"cache_hits":      2,
+		"cache_misses":    4,
+		"cache_evictions": 2,
+	} {
+		if got := stats[key].(int64); got != want {
+			t.Errorf("%s = %d, want %d", key, got, want)
+		}
+	}
+	if size := stats["cache_size"].(int); size != 2 {
+		t.Errorf("cache_size = %d, want 2", size)
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// NavigationExport - все данные навигации одного пользователя для выгрузки по его запросу
+type NavigationExport struct {
+	UserID     int64             `json:"user_id"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Navigation []NavigationState `json:"navigation"`
+}
+
+// ExportUser возвращает в JSON все данные навигации пользователя во всех чатах, темах и сообщениях
+// Стеки, еще не записанные из очереди, новее хранилища и попадают в выгрузку вместо него с отметкой pending
+func (pnm *PersistentNavigationManager) ExportUser(ctx context.Context, userID int64) ([]byte, error) {
+	if !pnm.breaker.Allow() {
+		return nil, storeUnavailable("export", NavigationScope{UserID: userID}, ErrCircuitOpen)
+	}
+
+	states, err := pnm.store.ExportUser(ctx, userID)
+	pnm.breaker.Record(err)
+	if err != nil {
+		return nil, err
+	}
+
+	pending := pnm.writes.PeekUser(userID)
+	for i, state := range states {
+		if record, exists := pending[state.scope()]; exists {
+			states[i] = pendingNavigationState(state.scope(), record)
+			delete(pending, state.scope())
+		}
+	}
+	for scope, record := range pending {
+		states = append(states, pendingNavigationState(scope, record))
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		a, b := states[i], states[j]
+		if a.ChatID != b.ChatID {
+			return a.ChatID < b.ChatID
+		}
+		if a.ThreadID != b.ThreadID {
+			return a.ThreadID < b.ThreadID
+		}
+		return a.MessageID < b.MessageID
+	})
+
+	export := NavigationExport{
+		UserID:     userID,
+		ExportedAt: time.Now().UTC(),
+		Navigation: append([]NavigationState{}, states...),
+	}
+	return json.MarshalIndent(export, "", "  ")
+}
+
+// pendingNavigationState - стек из очереди записи в том виде, в каком он стоит в очереди
+// Версия - та, от которой он будет записан, времени записи в хранилище у него еще нет
+func pendingNavigationState(scope NavigationScope, record NavigationRecord) NavigationState {
+	return NavigationState{
+		UserID:    scope.UserID,
+		ChatID:    scope.ChatID,
+		ThreadID:  scope.ThreadID,
+		MessageID: scope.MessageID,
+		MenuStack: append([]string(nil), record.Stack...),
+		Version:   record.Version,
+		ClickedAt: record.ClickedAt,
+		Pending:   true,
+	}
+}
+
+// EraseUser удаляет все данные навигации пользователя из кэша, очереди записи и хранилища
+// Другие реплики получают событие и тоже забывают пользователя.
+// Возвращает количество удаленных из хранилища записей.
+func (pnm *PersistentNavigationManager) EraseUser(ctx context.Context, userID int64) (int64, error) {
+	scope := NavigationScope{UserID: userID}
+	if !pnm.breaker.Allow() {
+		return 0, storeUnavailable("erase", scope, ErrCircuitOpen)
+	}
+
+	// Сначала очередь: стеки пользователя больше не пишутся, а уже начатая пачка
+	// дописывается до удаления из хранилища и не вернет их обратно
+	dropped := pnm.writes.BeginErase(userID)
+	defer pnm.writes.EndErase(userID)
+	pnm.cache.RemoveUser(userID)
+
+	removed, err := pnm.store.EraseUser(ctx, userID)
+	pnm.breaker.Record(err)
+	if err != nil {
+		return 0, err
+	}
+
+	// Клик во время удаления мог снова загрузить стек в кэш
+	dropped += pnm.writes.DropUser(userID)
+	pnm.cache.RemoveUser(userID)
+
+	if sub := pnm.invalidation.Load(); sub != nil {
+		event := Invalidation{Origin: pnm.instanceID, Users: []int64{userID}}
+		if err := sub.bus.Publish(ctx, event); err != nil {
+			log.Printf("⚠️  Не удалось разослать удаление навигации %v: %v", scope, err)
+		}
+	}
+
+	log.Printf("🧹 Данные навигации %v удалены: %d записей, %d из очереди", scope, removed, dropped)
+	return removed, nil
+}
+
+// RunNavigationDataCommand выполняет запрос о данных навигации пользователя
+// export <user_id> пишет JSON в w, erase <user_id> удаляет все данные пользователя
+func RunNavigationDataCommand(ctx context.Context, w io.Writer, pnm *PersistentNavigationManager, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: export <user_id> | erase <user_id>")
+	}
+
+	userID, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("bad user id %q", args[1])
+	}
+
+	switch args[0] {
+	case "export":
+		data, err := pnm.ExportUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(data)
+		return err
+
+	case "erase":
+		removed, err := pnm.EraseUser(ctx, userID)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "Данные навигации пользователя %d удалены, записей: %d\n", userID, removed)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown navigation data command %q, expected export or erase", args[0])
+	}
+}
+
+// NavigationDataHandler - команда бота для администраторов: /navdata export|erase <user_id>
+// Выгрузка приходит JSON файлом, остальным пользователям команда недоступна
+func NavigationDataHandler(pnm *PersistentNavigationManager, admins ...int64) tele.HandlerFunc {
+	allowed := make(map[int64]bool, len(admins))
+	for _, id := range admins {
+		allowed[id] = true
+	}
+
+	return func(c tele.Context) error {
+		if c.Sender() == nil || !allowed[c.Sender().ID] {
+			log.Printf("🚫 Запрос данных навигации не от администратора: %v", ScopeFromContext(c))
+			return c.Send("Команда доступна только администраторам")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		args := c.Args()
+		var out bytes.Buffer
+		if err := RunNavigationDataCommand(ctx, &out, pnm, args); err != nil {
+			log.Printf("❌ Ошибка команды данных навигации %q: %v", args, err)
+			return c.Send("❌ " + err.Error())
+		}
+
+		if args[0] == "export" {
+			return c.Send(&tele.Document{
+				File:     tele.FromReader(&out),
+				FileName: fmt.Sprintf("navigation_%s.json", args[1]),
+			})
+		}
+		return c.Send(out.String())
+	}
+}
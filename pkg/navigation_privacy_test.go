@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestExportUserPendingAsQueued(t *testing.T) {
+	store := newBlockingStore()
+	ctx := context.Background()
+	group := NavigationScope{UserID: 1, ChatID: -100}
+
+	if _, err := store.Save(ctx, group, NavigationRecord{Stack: []string{"main"}, ClickedAt: time.Now().UTC()}); err != nil {
+		t.Fatal(err)
+	}
+
+	pnm := NewPersistentNavigationManagerWithStore(store)
+	defer func() {
+		close(store.release)
+		pnm.Close(ctx)
+	}()
+
+	// Первая запись занимает воркер, стек группы остается в очереди
+	if err := pnm.PushMenu(ctx, UserScope(1), "main"); err != nil {
+		t.Fatal(err)
+	}
+	<-store.started
+	if err := pnm.PushMenu(ctx, group, "help"); err != nil {
+		t.Fatal(err)
+	}
+	queued, _ := pnm.writes.Peek(group)
+
+	data, err := pnm.ExportUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export NavigationExport
+	if err := json.Unmarshal(data, &export); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, state := range export.Navigation {
+		if state.scope() != group {
+			continue
+		}
+		if !state.Pending || state.Version != queued.Version || !state.UpdatedAt.IsZero() ||
+			!state.ClickedAt.Equal(queued.ClickedAt) || !reflect.DeepEqual(state.MenuStack, []string{"main", "help"}) {
+			t.Errorf("exported pending state = %+v, queued %+v", state, queued)
+		}
+		return
+	}
+	t.Errorf("pending stack is missing from the export: %s", data)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// NavigationStore - хранилище стеков навигации для PersistentNavigationManager
+// Позволяет использовать один менеджер с PostgreSQL, SQLite, файлом или памятью.
+// Все методы принимают context для таймаутов и отмены и возвращают ошибки StoreError.
+type NavigationStore interface {
+	// Load возвращает стек пользователя с версией, для нового пользователя - ErrNavigationNotFound
+	Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error)
+
+	// Save сохраняет стек, только если в хранилище все еще record.Version
+	// (0 - записи еще нет), и возвращает новую версию.
+	// Если запись успела измениться - ErrVersionConflict.
+	Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error)
+
+	// Delete удаляет стек пользователя
+	Delete(ctx context.Context, scope NavigationScope) error
+
+	// Cleanup удаляет стеки с истекшим по policy сроком хранения и возвращает их количество
+	Cleanup(ctx context.Context, policy RetentionPolicy) (int64, error)
+
+	// ExportUser возвращает все стеки пользователя во всех чатах, темах и сообщениях
+	ExportUser(ctx context.Context, userID int64) ([]NavigationState, error)
+
+	// EraseUser удаляет все стеки пользователя и возвращает их количество
+	EraseUser(ctx context.Context, userID int64) (int64, error)
+
+	// Stats возвращает статистику хранилища
+	Stats(ctx context.Context) (StoreStats, error)
+}
+
+// NavigationRecord - стек пользователя с версией для оптимистичной блокировки
+type NavigationRecord struct {
+	Stack     []string
+	Version   int64     // Версия записи в хранилище, 0 - записи нет
+	ClickedAt time.Time // Время клика, который привел к этому стеку (UTC)
+	Degraded  bool      // Стек начат без хранилища, при записи дописывается к сохраненному
+}
+
+// StoreStats - статистика хранилища навигации
+type StoreStats struct {
+	Records  int
+	AvgDepth float64
+	MaxDepth int
+}
+
+// Виды ошибок хранилища, проверяются через errors.Is
+var (
+	ErrStoreUnavailable   = errors.New("navigation store is unavailable")
+	ErrCorruptStack       = errors.New("navigation stack is corrupt")
+	ErrNavigationNotFound = errors.New("navigation stack not found")
+	ErrVersionConflict    = errors.New("navigation stack was changed concurrently")
+)
+
+// StoreError - ошибка операции с хранилищем навигации
+// errors.Is(err, ErrStoreUnavailable) проверяет вид ошибки,
+// errors.Is(err, context.DeadlineExceeded) - исходную причину
+type StoreError struct {
+	Op    string          // load, save, delete, cleanup, export, erase, stats, schema
+	Scope NavigationScope // Пустая, если операция не относится к пользователю
+	Kind  error           // ErrStoreUnavailable, ErrCorruptStack, ErrNavigationNotFound или ErrVersionConflict
+	Err   error           // Исходная ошибка драйвера, может быть nil
+}
+
+func (se *StoreError) Error() string {
+	msg := "navigation store: " + se.Op
+	if se.Scope != (NavigationScope{}) {
+		msg += " " + se.Scope.String()
+	}
+	msg += ": " + se.Kind.Error()
+	if se.Err != nil {
+		msg += ": " + se.Err.Error()
+	}
+	return msg
+}
+
+func (se *StoreError) Is(target error) bool {
+	return target == se.Kind
+}
+
+func (se *StoreError) Unwrap() error {
+	return se.Err
+}
+
+// storeUnavailable оборачивает ошибку драйвера, nil остается nil
+func storeUnavailable(op string, scope NavigationScope, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &StoreError{Op: op, Scope: scope, Kind: ErrStoreUnavailable, Err: err}
+}
+
+func storeCorrupt(op string, scope NavigationScope, err error) error {
+	return &StoreError{Op: op, Scope: scope, Kind: ErrCorruptStack, Err: err}
+}
+
+func storeNotFound(op string, scope NavigationScope) error {
+	return &StoreError{Op: op, Scope: scope, Kind: ErrNavigationNotFound}
+}
+
+func storeConflict(op string, scope NavigationScope) error {
+	return &StoreError{Op: op, Scope: scope, Kind: ErrVersionConflict}
+}
+
+// MemoryNavigationStore - хранилище в памяти процесса
+// Подходит для тестов и небольших ботов, не переживает рестарт
+type MemoryNavigationStore struct {
+	states map[NavigationScope]NavigationState
+	mutex  sync.RWMutex
+}
+
+func NewMemoryNavigationStore() *MemoryNavigationStore {
+	return &MemoryNavigationStore{
+		states: make(map[NavigationScope]NavigationState),
+	}
+}
+
+// Load возвращает копию стека пользователя
+func (mns *MemoryNavigationStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return NavigationRecord{}, storeUnavailable("load", scope, err)
+	}
+
+	mns.mutex.RLock()
+	defer mns.mutex.RUnlock()
+
+	state, exists := mns.states[scope]
+	if !exists {
+		return NavigationRecord{}, storeNotFound("load", scope)
+	}
+	return state.record(), nil
+}
+
+// Save сохраняет копию стека, чтобы вызывающий код не менял его извне
+func (mns *MemoryNavigationStore) Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("save", scope, err)
+	}
+
+	mns.mutex.Lock()
+	defer mns.mutex.Unlock()
+
+	if mns.states[scope].Version != record.Version {
+		return 0, storeConflict("save", scope)
+	}
+
+	mns.states[scope] = newNavigationState(scope, record)
+	return record.Version + 1, nil
+}
+
+// Delete удаляет стек пользователя
+func (mns *MemoryNavigationStore) Delete(ctx context.Context, scope NavigationScope) error {
+	if err := ctx.Err(); err != nil {
+		return storeUnavailable("delete", scope, err)
+	}
+
+	mns.mutex.Lock()
+	defer mns.mutex.Unlock()
+
+	delete(mns.states, scope)
+	return nil
+}
+
+// Cleanup удаляет устаревшие стеки
+func (mns *MemoryNavigationStore) Cleanup(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("cleanup", NavigationScope{}, err)
+	}
+
+	mns.mutex.Lock()
+	defer mns.mutex.Unlock()
+
+	now := time.Now()
+	var removed int64
+
+	for scope, state := range mns.states {
+		if policy.Expired(scope, state.UpdatedAt, now) {
+			delete(mns.states, scope)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// ExportUser возвращает копии всех стеков пользователя
+func (mns *MemoryNavigationStore) ExportUser(ctx context.Context, userID int64) ([]NavigationState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+	}
+
+	mns.mutex.RLock()
+	defer mns.mutex.RUnlock()
+
+	var states []NavigationState
+	for scope, state := range mns.states {
+		if scope.UserID == userID {
+			state.MenuStack = append([]string(nil), state.MenuStack...)
+			states = append(states, state)
+		}
+	}
+	return states, nil
+}
+
+// EraseUser удаляет все стеки пользователя
+func (mns *MemoryNavigationStore) EraseUser(ctx context.Context, userID int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+	}
+
+	mns.mutex.Lock()
+	defer mns.mutex.Unlock()
+
+	var removed int64
+	for scope := range mns.states {
+		if scope.UserID == userID {
+			delete(mns.states, scope)
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// Stats считает статистику по всем стекам
+func (mns *MemoryNavigationStore) Stats(ctx context.Context) (StoreStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StoreStats{}, storeUnavailable("stats", NavigationScope{}, err)
+	}
+
+	mns.mutex.RLock()
+	defer mns.mutex.RUnlock()
+
+	var stats StoreStats
+	var totalDepth int
+
+	for _, state := range mns.states {
+		depth := len(state.MenuStack)
+		totalDepth += depth
+		if depth > stats.MaxDepth {
+			stats.MaxDepth = depth
+		}
+	}
+
+	stats.Records = len(mns.states)
+	if stats.Records > 0 {
+		stats.AvgDepth = float64(totalDepth) / float64(stats.Records)
+	}
+	return stats, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"strings"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// Navigator - общий интерфейс для всех стратегий навигации
+// Позволяет менять стратегию конфигурацией, не переписывая обработчики меню
+type Navigator interface {
+	// MenuButton создает кнопку перехода с экрана from в меню menuID
+	MenuButton(from Screen, text, menuID string) *tele.Btn
+
+	// BackButton создает кнопку "назад" для экрана (nil, если возвращаться некуда)
+	BackButton(screen Screen) *tele.Btn
+
+	// Open фиксирует, что пользователь открыл экран
+	Open(screen Screen) error
+
+	// Back возвращает экран, предыдущий относительно screen
+	Back(screen Screen) (Screen, bool, error)
+
+	// Resolve превращает callback_data в экран назначения в области scope
+	// ok == false означает, что callback не относится к навигации
+	Resolve(scope NavigationScope, callbackData string) (target Screen, ok bool, err error)
+}
+
+// MessageBinder - стратегия, которая хранит историю отдельно для каждого сообщения с меню
+// Пока меню не отправлено, ID сообщения неизвестен и история копится в области без сообщения.
+type MessageBinder interface {
+	// BindMessage переносит историю экрана screen на только что отправленное сообщение
+	BindMessage(screen Screen, messageID int) error
+}
+
+// Screen - экран (меню), который видит пользователь
+type Screen struct {
+	Scope  NavigationScope // Пользователь, чат и тема, где открыт экран
+	MenuID string
+	Path   []string // Путь от корня до MenuID включительно, если известен
+}
+
+// ErrUnknownScreen возвращается, когда стратегия не может определить текущий экран
+var ErrUnknownScreen = errors.New("current screen is unknown")
+
+// Проверяем, что все стратегии реализуют Navigator
+var (
+	_ Navigator = (*UltraSimpleNavigation)(nil)
+	_ Navigator = (*StatelessNavigationManager)(nil)
+	_ Navigator = (*PersistentNavigationManager)(nil)
+	_ Navigator = (*HierarchicalNavigation)(nil)
+
+	_ MessageBinder = (*PersistentNavigationManager)(nil)
+)
+
+// NewScreen создает экран с путем, продолженным от экрана from
+func NewScreen(from Screen, menuID string) Screen {
+	path := make([]string, 0, len(from.Path)+1)
+	path = append(path, from.Path...)
+	path = append(path, menuID)
+
+	return Screen{Scope: from.Scope, MenuID: menuID, Path: path}
+}
+
+// ParentScreen возвращает экран на уровень выше по пути screen.Path
+func ParentScreen(screen Screen) (Screen, bool) {
+	if len(screen.Path) <= 1 {
+		return Screen{}, false
+	}
+
+	path := screen.Path[:len(screen.Path)-1]
+	return Screen{
+		Scope:  screen.Scope,
+		MenuID: path[len(path)-1],
+		Path:   append([]string(nil), path...),
+	}, true
+}
+
+// normalizeCallbackData убирает служебный префикс telebot "\f" из callback_data
+func normalizeCallbackData(data string) string {
+	return strings.TrimPrefix(data, "\f")
+}
+
+// appendButtonRow добавляет ряд с одной кнопкой к inline-клавиатуре
+func appendButtonRow(keyboard *tele.ReplyMarkup, btn *tele.Btn) {
+	if btn == nil {
+		return
+	}
+	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tele.InlineButton{*btn.Inline()})
+}
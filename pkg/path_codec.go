@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// ErrStalePath - путь закодирован по другой иерархии (меню перезагружено)
+// Индексы в нем могут указывать на других детей, поэтому путь не декодируется вовсе
+var ErrStalePath = errors.New("navigation path was encoded for another menu hierarchy")
+
+// PathCodec - компактный кодек пути навигации для callback_data
+// Каждый уровень кодируется индексом среди детей родителя (varint),
+// поэтому путь из 8+ уровней занимает несколько байт вместо JSON.
+// Первые 4 байта - отпечаток иерархии: после ее изменения старые пути не декодируются в чужие.
+// Однобайтовый отпечаток пропускал бы каждую 256-ю измененную иерархию.
+type PathCodec struct {
+	children    map[string][]string       // parent_id -> отсортированные дети ("" - корни)
+	index       map[string]map[string]int // parent_id -> child_id -> индекс
+	fingerprint uint32                    // Отпечаток иерархии, по которой построены индексы
+}
+
+// pathFingerprintSize - размер отпечатка иерархии в начале закодированного пути
+const pathFingerprintSize = 4
+
+// NewPathCodec строит кодек по иерархии menu_id -> parent_id
+// Обе стороны (кодирование и декодирование) должны использовать одну и ту же иерархию
+func NewPathCodec(hierarchy map[string]string) *PathCodec {
+	parents := make(map[string][]string, len(hierarchy))
+	for menuID, parentID := range hierarchy {
+		parents[menuID] = []string{parentID}
+	}
+	return NewPathCodecFromParents(parents)
+}
+
+// NewPathCodecFromParents строит кодек по иерархии, где у меню может быть несколько родителей
+// Меню индексируется среди детей каждого своего родителя
+func NewPathCodecFromParents(parents map[string][]string) *PathCodec {
+	pc := &PathCodec{
+		children: make(map[string][]string),
+		index:    make(map[string]map[string]int),
+	}
+
+	// Корни - родители, у которых нет своего родителя
+	roots := make(map[string]bool)
+	for menuID, ids := range parents {
+		for _, parentID := range ids {
+			pc.children[parentID] = append(pc.children[parentID], menuID)
+			if _, hasParent := parents[parentID]; !hasParent {
+				roots[parentID] = true
+			}
+		}
+	}
+	for root := range roots {
+		pc.children[""] = append(pc.children[""], root)
+	}
+
+	// Сортируем, чтобы индексы не зависели от порядка обхода map
+	for parentID, ids := range pc.children {
+		sort.Strings(ids)
+		pc.index[parentID] = make(map[string]int, len(ids))
+		for i, id := range ids {
+			pc.index[parentID][id] = i
+		}
+	}
+	pc.fingerprint = pc.hierarchyFingerprint()
+
+	return pc
+}
+
+// hierarchyFingerprint хэширует списки детей - все, от чего зависят индексы
+func (pc *PathCodec) hierarchyFingerprint() uint32 {
+	parentIDs := make([]string, 0, len(pc.children))
+	for parentID := range pc.children {
+		parentIDs = append(parentIDs, parentID)
+	}
+	sort.Strings(parentIDs)
+
+	h := fnv.New32a()
+	for _, parentID := range parentIDs {
+		h.Write([]byte(parentID))
+		h.Write([]byte{0})
+		for _, id := range pc.children[parentID] {
+			h.Write([]byte(id))
+			h.Write([]byte{1})
+		}
+		h.Write([]byte{2})
+	}
+
+	return h.Sum32()
+}
+
+// Encode кодирует путь в base64url без padding
+// Меню вне иерархии кодируются литералом: 0, длина, байты ID
+func (pc *PathCodec) Encode(path []string) string {
+	if len(path) == 0 {
+		return ""
+	}
+
+	buf := make([]byte, pathFingerprintSize, len(path)*2+pathFingerprintSize)
+	binary.BigEndian.PutUint32(buf, pc.fingerprint)
+	parentID := ""
+
+	for _, menuID := range path {
+		if i, known := pc.index[parentID][menuID]; known {
+			buf = binary.AppendUvarint(buf, uint64(i+1))
+		} else {
+			buf = binary.AppendUvarint(buf, 0)
+			buf = binary.AppendUvarint(buf, uint64(len(menuID)))
+			buf = append(buf, menuID...)
+		}
+		parentID = menuID
+	}
+
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// Decode восстанавливает путь из строки, созданной Encode
+// Путь, закодированный по другой иерархии, возвращает ErrStalePath
+func (pc *PathCodec) Decode(encoded string) ([]string, error) {
+	if encoded == "" {
+		return []string{}, nil
+	}
+
+	buf, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base64: %v", err)
+	}
+	if len(buf) < pathFingerprintSize || binary.BigEndian.Uint32(buf) != pc.fingerprint {
+		return nil, ErrStalePath
+	}
+	buf = buf[pathFingerprintSize:]
+
+	var path []string
+	parentID := ""
+
+	for len(buf) > 0 {
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid varint at level %d", len(path))
+		}
+		buf = buf[n:]
+
+		var menuID string
+		if value == 0 {
+			length, n := binary.Uvarint(buf)
+			if n <= 0 || uint64(len(buf)-n) < length {
+				return nil, fmt.Errorf("invalid literal at level %d", len(path))
+			}
+			menuID = string(buf[n : n+int(length)])
+			buf = buf[n+int(length):]
+		} else {
+			siblings := pc.children[parentID]
+			if value > uint64(len(siblings)) {
+				return nil, fmt.Errorf("index %d out of range at level %d", value-1, len(path))
+			}
+			menuID = siblings[value-1]
+		}
+
+		path = append(path, menuID)
+		parentID = menuID
+	}
+
+	return path, nil
+}
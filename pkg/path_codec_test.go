@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestPathCodecRoundTrip(t *testing.T) {
+	codec := NewPathCodecFromParents(map[string][]string{
+		"stats":         {"main"},
+		"channels":      {"main"},
+		"channel_stats": {"stats", "channels"},
+	})
+
+	paths := [][]string{
+		{"main"},
+		{"main", "stats", "channel_stats"},
+		{"main", "channels", "channel_stats"},
+		{"main", "unknown", "stats"}, // Меню вне иерархии кодируется литералом
+	}
+	for _, path := range paths {
+		encoded := codec.Encode(path)
+		decoded, err := codec.Decode(encoded)
+		if err != nil {
+			t.Fatalf("decode %v (%q): %v", path, encoded, err)
+		}
+		if !reflect.DeepEqual(decoded, path) {
+			t.Errorf("decode(encode(%v)) = %v", path, decoded)
+		}
+	}
+}
+
+func TestPathCodecStaleAfterSiblingAdded(t *testing.T) {
+	old := NewPathCodec(map[string]string{"settings": "main", "stats": "main"})
+	encoded := old.Encode([]string{"main", "stats"})
+
+	// "about" встает перед "stats" среди отсортированных детей и сдвигает индексы
+	reloaded := NewPathCodec(map[string]string{"about": "main", "settings": "main", "stats": "main"})
+	if path, err := reloaded.Decode(encoded); !errors.Is(err, ErrStalePath) {
+		t.Errorf("decode with new hierarchy = %v, %v, want ErrStalePath", path, err)
+	}
+
+	// Та же иерархия, построенная заново, декодирует путь
+	same := NewPathCodec(map[string]string{"stats": "main", "settings": "main"})
+	if path, err := same.Decode(encoded); err != nil || !reflect.DeepEqual(path, []string{"main", "stats"}) {
+		t.Errorf("decode with same hierarchy = %v, %v", path, err)
+	}
+}
+
+// Однобайтовый отпечаток пропускал примерно каждую 256-ю измененную иерархию
+func TestPathCodecStaleManyHierarchies(t *testing.T) {
+	base := map[string]string{"settings": "main", "stats": "main"}
+	encoded := NewPathCodec(base).Encode([]string{"main", "stats"})
+
+	for i := 0; i < 2000; i++ {
+		changed := map[string]string{"settings": "main", "stats": "main", fmt.Sprintf("menu_%d", i): "main"}
+		if path, err := NewPathCodec(changed).Decode(encoded); !errors.Is(err, ErrStalePath) {
+			t.Fatalf("hierarchy with menu_%d decoded a stale path as %v, %v", i, path, err)
+		}
+	}
+}
+
+func TestPathCodecInvalid(t *testing.T) {
+	codec := NewPathCodec(map[string]string{"settings": "main"})
+	encoded := codec.Encode([]string{"main", "settings"})
+
+	for _, data := range []string{"!!", encoded[:1], encoded + "_w"} {
+		if path, err := codec.Decode(data); err == nil {
+			t.Errorf("decode %q = %v, want error", data, path)
+		}
+	}
+}
+
+func TestStatelessStaleBackButton(t *testing.T) {
+	snm := NewStatelessNavigationManager()
+	snm.SetPathCodec(NewPathCodec(map[string]string{"settings": "main", "stats": "main"}))
+	btn := snm.CreateBackButton([]string{"main", "stats"})
+
+	snm.SetPathCodec(NewPathCodec(map[string]string{"about": "main", "settings": "main", "stats": "main"}))
+	screen, handled, err := snm.Resolve(NavigationScope{}, btn.Unique)
+	if err != nil || !handled {
+		t.Fatalf("resolve = %v, %v", handled, err)
+	}
+	if screen.MenuID != "main" {
+		t.Errorf("stale back button opened %q, want main", screen.MenuID)
+	}
+}
+
+// deepHierarchy - иерархия бота с синтетической цепочкой level_1 -> ... -> level_10
+func deepHierarchy() (map[string]string, []string) {
+	hierarchy := NewHierarchicalNavigation().Hierarchy()
+	path := []string{"main"}
+	for i := 1; i <= 10; i++ {
+		menuID := fmt.Sprintf("level_%d", i)
+		hierarchy[menuID] = path[len(path)-1]
+		path = append(path, menuID)
+	}
+	return hierarchy, path
+}
+
+func BenchmarkPathCodec(b *testing.B) {
+	hierarchy, path := deepHierarchy()
+	compact := NewStatelessNavigationManager()
+	compact.SetPathCodec(NewPathCodec(hierarchy))
+
+	for name, snm := range map[string]*StatelessNavigationManager{
+		"json":    NewStatelessNavigationManager(),
+		"compact": compact,
+	} {
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.ReportMetric(float64(len(snm.backBtnPrefix+snm.encodePath(path))), "callback-bytes")
+			for i := 0; i < b.N; i++ {
+				if _, err := snm.decodePath(snm.encodePath(path)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bufio"
+	"container/list"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// PathOverflowStore - хранилище путей, не влезающих в 64 байта callback_data
+// В кнопку кладется короткий хэш, а полный путь достается из хранилища по нему
+type PathOverflowStore interface {
+	Put(key string, path []string) error
+	Get(key string) ([]string, bool, error)
+}
+
+// ErrPathExpired - путь по хэшу не найден: запись вытеснена, истекла или хранилище очищено
+// Кнопка "назад" в этом случае ведет в главное меню (см. StatelessNavigationManager.Resolve)
+var ErrPathExpired = errors.New("navigation path has expired")
+
+// MemoryPathStore - LRU хранилище путей в памяти
+// Самые давно использованные пути вытесняются при превышении capacity
+type MemoryPathStore struct {
+	capacity int
+	entries  map[string]*list.Element
+	order    *list.List // Начало списка - самые свежие записи
+	mutex    sync.Mutex
+}
+
+type memoryPathEntry struct {
+	key  string
+	path []string
+}
+
+func NewMemoryPathStore(capacity int) *MemoryPathStore {
+	return &MemoryPathStore{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Put сохраняет путь и вытесняет самую старую запись при переполнении
+func (mps *MemoryPathStore) Put(key string, path []string) error {
+	mps.mutex.Lock()
+	defer mps.mutex.Unlock()
+
+	if elem, exists := mps.entries[key]; exists {
+		elem.Value.(*memoryPathEntry).path = path
+		mps.order.MoveToFront(elem)
+		return nil
+	}
+
+	mps.entries[key] = mps.order.PushFront(&memoryPathEntry{key: key, path: path})
+
+	if mps.order.Len() > mps.capacity {
+		oldest := mps.order.Back()
+		mps.order.Remove(oldest)
+		delete(mps.entries, oldest.Value.(*memoryPathEntry).key)
+	}
+	return nil
+}
+
+// Get возвращает путь и отмечает запись как недавно использованную
+func (mps *MemoryPathStore) Get(key string) ([]string, bool, error) {
+	mps.mutex.Lock()
+	defer mps.mutex.Unlock()
+
+	elem, exists := mps.entries[key]
+	if !exists {
+		return nil, false, nil
+	}
+	mps.order.MoveToFront(elem)
+	return elem.Value.(*memoryPathEntry).path, true, nil
+}
+
+// FilePathStore - хранилище путей в файле, переживает рестарт бота
+// Записи дописываются в конец файла (JSON Lines), индекс держится в памяти.
+// Cleanup удаляет давно не использованные пути и переписывает файл без них.
+type FilePathStore struct {
+	filename string
+	file     *os.File
+	entries  map[string]filePathEntry
+	lines    int // Строк в файле, включая перекрытые более поздними
+	mutex    sync.Mutex
+}
+
+type filePathEntry struct {
+	path   []string
+	usedAt time.Time
+}
+
+type filePathRecord struct {
+	Key  string   `json:"k"`
+	Path []string `json:"p"`
+	At   int64    `json:"t,omitempty"` // Время записи (Unix), в строках старого формата нет
+}
+
+// filePathRefresh - как часто повторный Put обновляет время пути в файле
+// Чаще не нужно: Cleanup удаляет пути, не использованные гораздо дольше
+const filePathRefresh = time.Hour
+
+func NewFilePathStore(filename string) (*FilePathStore, error) {
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	fps := &FilePathStore{
+		filename: filename,
+		file:     file,
+		entries:  make(map[string]filePathEntry),
+	}
+
+	// Восстанавливаем индекс, поздние записи перекрывают ранние
+	loadedAt := time.Now()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record filePathRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			continue // Недописанная при падении строка
+		}
+		fps.lines++
+
+		// Путям без времени срок считается с момента загрузки
+		usedAt := loadedAt
+		if record.At != 0 {
+			usedAt = time.Unix(record.At, 0)
+		}
+		fps.entries[record.Key] = filePathEntry{path: record.Path, usedAt: usedAt}
+	}
+	if err := scanner.Err(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("read %s: %v", filename, err)
+	}
+
+	return fps, nil
+}
+
+// Put дописывает путь в файл
+// Повторный Put того же пути продлевает его срок, но пишет в файл не чаще filePathRefresh
+func (fps *FilePathStore) Put(key string, path []string) error {
+	fps.mutex.Lock()
+	defer fps.mutex.Unlock()
+
+	now := time.Now()
+	if entry, exists := fps.entries[key]; exists && now.Sub(entry.usedAt) < filePathRefresh {
+		return nil // Хэш однозначно определяется путем
+	}
+
+	line, err := json.Marshal(filePathRecord{Key: key, Path: path, At: now.Unix()})
+	if err != nil {
+		return err
+	}
+	if _, err := fps.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+
+	fps.entries[key] = filePathEntry{path: path, usedAt: now}
+	fps.lines++
+	return nil
+}
+
+// Get возвращает путь по хэшу
+func (fps *FilePathStore) Get(key string) ([]string, bool, error) {
+	fps.mutex.Lock()
+	defer fps.mutex.Unlock()
+
+	entry, exists := fps.entries[key]
+	return entry.path, exists, nil
+}
+
+// Cleanup удаляет пути, не записанные повторно дольше maxAge, кнопки с ними будут вести в главное меню
+// Файл переписывается без удаленных и перекрытых строк, поэтому не растет бесконечно
+func (fps *FilePathStore) Cleanup(maxAge time.Duration) (int64, error) {
+	fps.mutex.Lock()
+	defer fps.mutex.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	var removed int64
+	for key, entry := range fps.entries {
+		if entry.usedAt.Before(cutoff) {
+			delete(fps.entries, key)
+			removed++
+		}
+	}
+
+	if fps.lines == len(fps.entries) {
+		return removed, nil // Сжимать нечего
+	}
+	return removed, fps.compact()
+}
+
+// compact переписывает файл только с актуальными путями
+// Новый файл пишется рядом и подменяет старый целиком, падение посередине ничего не теряет
+func (fps *FilePathStore) compact() error {
+	tmp := fps.filename + ".tmp"
+	file, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	for key, entry := range fps.entries {
+		line, err := json.Marshal(filePathRecord{Key: key, Path: entry.path, At: entry.usedAt.Unix()})
+		if err != nil {
+			file.Close()
+			os.Remove(tmp)
+			return err
+		}
+		w.Write(append(line, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := os.Rename(tmp, fps.filename); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	// Старый дескриптор указывает на замененный файл - дописываем уже в новый
+	reopened, err := os.OpenFile(fps.filename, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fps.file.Close()
+	fps.file = reopened
+	fps.lines = len(fps.entries)
+	return nil
+}
+
+// Close закрывает файл хранилища
+func (fps *FilePathStore) Close() error {
+	fps.mutex.Lock()
+	defer fps.mutex.Unlock()
+
+	return fps.file.Close()
+}
+
+// SQLPathStore - хранилище путей в PostgreSQL, общее для нескольких реплик бота
+type SQLPathStore struct {
+	db *sql.DB
+}
+
+func NewSQLPathStore(db *sql.DB) (*SQLPathStore, error) {
+	query := `
+    CREATE TABLE IF NOT EXISTS navigation_path_overflow (
+        path_key TEXT PRIMARY KEY,
+        path JSONB NOT NULL,
+        created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+    );
+    `
+
+	if _, err := db.Exec(query); err != nil {
+		return nil, fmt.Errorf("create navigation_path_overflow: %v", err)
+	}
+	return &SQLPathStore{db: db}, nil
+}
+
+// Put сохраняет путь по хэшу
+// Повторное сохранение продлевает срок пути: на него ссылаются только что показанные кнопки
+func (sps *SQLPathStore) Put(key string, path []string) error {
+	pathJSON, err := json.Marshal(path)
+	if err != nil {
+		return err
+	}
+
+	query := `
+    INSERT INTO navigation_path_overflow (path_key, path)
+    VALUES ($1, $2)
+    ON CONFLICT (path_key) DO UPDATE SET created_at = CURRENT_TIMESTAMP
+    `
+
+	_, err = sps.db.Exec(query, key, pathJSON)
+	return err
+}
+
+// Get возвращает путь по хэшу
+func (sps *SQLPathStore) Get(key string) ([]string, bool, error) {
+	var pathJSON []byte
+	query := "SELECT path FROM navigation_path_overflow WHERE path_key = $1"
+
+	err := sps.db.QueryRow(query, key).Scan(&pathJSON)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	var path []string
+	if err := json.Unmarshal(pathJSON, &path); err != nil {
+		return nil, false, err
+	}
+	return path, true, nil
+}
+
+// Cleanup удаляет пути старше maxAge, кнопки с ними будут вести в главное меню
+func (sps *SQLPathStore) Cleanup(maxAge time.Duration) (int64, error) {
+	query := "DELETE FROM navigation_path_overflow WHERE created_at < $1"
+
+	result, err := sps.db.Exec(query, time.Now().Add(-maxAge))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
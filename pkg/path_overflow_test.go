@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMemoryPathStoreEvictsLeastRecentlyUsed(t *testing.T) {
+	store := NewMemoryPathStore(2)
+	store.Put("a", []string{"main", "a"})
+	store.Put("b", []string{"main", "b"})
+
+	// Чтение освежает "a", поэтому вытесняется "b"
+	if _, found, _ := store.Get("a"); !found {
+		t.Fatal("a is missing")
+	}
+	store.Put("c", []string{"main", "c"})
+
+	for key, want := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, found, _ := store.Get(key); found != want {
+			t.Errorf("Get(%q) found = %v, want %v", key, found, want)
+		}
+	}
+}
+
+func TestFilePathStoreReopen(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "paths.jsonl")
+
+	store, err := NewFilePathStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("a", []string{"main", "settings"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Недописанная при падении строка не мешает загрузке
+	file, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"k":"b","p":["ma`)
+	file.Close()
+
+	store, err = NewFilePathStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	path, found, err := store.Get("a")
+	if err != nil || !found || !reflect.DeepEqual(path, []string{"main", "settings"}) {
+		t.Errorf("Get after reopen = %v, %v, %v", path, found, err)
+	}
+}
+
+func TestFilePathStoreCleanup(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "paths.jsonl")
+	old := time.Now().Add(-48 * time.Hour).Unix()
+	lines := []string{
+		fmt.Sprintf(`{"k":"old","p":["main","old"],"t":%d}`, old),
+		fmt.Sprintf(`{"k":"fresh","p":["main"],"t":%d}`, old),
+		fmt.Sprintf(`{"k":"fresh","p":["main","fresh"],"t":%d}`, time.Now().Unix()),
+	}
+	if err := os.WriteFile(filename, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewFilePathStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed, err := store.Cleanup(24 * time.Hour)
+	if err != nil || removed != 1 {
+		t.Fatalf("Cleanup = %d, %v, want 1 removed", removed, err)
+	}
+
+	// После сжатия новые пути дописываются в новый файл
+	if err := store.Put("next", []string{"main", "next"}); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != 2 {
+		t.Errorf("file has %d lines after compaction, want 2:\n%s", n, data)
+	}
+
+	store, err = NewFilePathStore(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+	if _, found, _ := store.Get("old"); found {
+		t.Error("expired path survived compaction")
+	}
+	if path, found, _ := store.Get("fresh"); !found || !reflect.DeepEqual(path, []string{"main", "fresh"}) {
+		t.Errorf("fresh path = %v, %v", path, found)
+	}
+}
+
+func TestSQLPathStoreRefreshesOnPut(t *testing.T) {
+	db := openTestDB(t, "postgres")
+	store, err := NewSQLPathStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Put("a", []string{"main", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE navigation_path_overflow SET created_at = created_at - INTERVAL '2 days'"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Кнопку с тем же путем показали снова - путь не должен истечь
+	if err := store.Put("a", []string{"main", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if removed, err := store.Cleanup(24 * time.Hour); err != nil || removed != 0 {
+		t.Errorf("Cleanup = %d, %v, want the refreshed path kept", removed, err)
+	}
+	if _, found, err := store.Get("a"); err != nil || !found {
+		t.Errorf("Get = %v, %v", found, err)
+	}
+}
+
+func TestStatelessExpiredOverflowPath(t *testing.T) {
+	snm := NewStatelessNavigationManager()
+	snm.SetOverflowStore(NewMemoryPathStore(1))
+
+	long := func(name string) []string {
+		return []string{"main", strings.Repeat(name, 20), strings.Repeat(name, 20), "leaf"}
+	}
+	expired := snm.CreateBackButton(long("a"))
+	if !strings.Contains(expired.Unique, "h:") {
+		t.Fatalf("path was not moved to the overflow store: %q", expired.Unique)
+	}
+	// Следующий длинный путь вытесняет первый из хранилища на одну запись
+	snm.CreateBackButton(long("b"))
+
+	screen, handled, err := snm.Resolve(UserScope(1), expired.Unique)
+	if err != nil || !handled {
+		t.Fatalf("Resolve = %v, %v", handled, err)
+	}
+	if screen.MenuID != "main" || !reflect.DeepEqual(screen.Path, []string{"main"}) {
+		t.Errorf("expired path resolved to %+v, want main", screen)
+	}
+}
@@ -1,95 +1,328 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"log"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
 	tele "gopkg.in/telebot.v3"
 )
 
-// PersistentNavigationManager - менеджер с сохранением в PostgreSQL
+// persistentBackUnique - кнопка "назад", снимающая меню со стека пользователя
+const persistentBackUnique = "persistent_back"
+
+// PersistentNavigationManager - менеджер с сохранением стека навигации в хранилище
+// По умолчанию - PostgreSQL, см. NavigationStore для других вариантов
 type PersistentNavigationManager struct {
-	db       *sql.DB
-	cache    map[int64][]string  // Кэш для быстрого доступа
-	cacheTTL map[int64]time.Time // TTL для элементов кэша
-	mutex    sync.RWMutex
+	store    NavigationStore
+	breaker  *circuitBreaker  // Переключает в режим "только память", пока хранилище недоступно
+	cache    *navigationCache // LRU кэш активных пользователей
+	locks    *userLocks       // Блокировки по пользователям вместо общего мьютекса
 	backBtn  *tele.Btn
+	envelope callbackEnvelope  // Версия callback_data кнопок меню
+	writes   *writeBehindQueue // Упорядоченная отложенная запись в хранилище
 
 	// Настройки оптимизации
-	maxCacheSize    int
-	cacheTimeout    time.Duration
-	maxStackDepth   int
-	cleanupInterval time.Duration
+	maxStackDepth    int
+	cleanupInterval  time.Duration
+	operationTimeout time.Duration                   // Таймаут обращения к хранилищу из методов Navigator
+	retention        atomic.Pointer[RetentionPolicy] // Сроки хранения истории, см. SetRetentionPolicy
+
+	// Согласование кэшей между репликами, см. SetInvalidationBus
+	instanceID            string
+	invalidation          atomic.Pointer[invalidationSubscription]
+	invalidationsSent     atomic.Int64
+	invalidationsReceived atomic.Int64
+
+	// Жизненный цикл фоновых процессов: ctx отменяется в Close и прерывает текущую запись
+	ctx       context.Context
+	stop      context.CancelFunc
+	wg        sync.WaitGroup
+	startOnce sync.Once
+	closeOnce sync.Once
+}
+
+// invalidationSubscription - подключенная шина и отмена подписки
+type invalidationSubscription struct {
+	bus         InvalidationBus
+	unsubscribe func()
+}
+
+// ShutdownReport - итог остановки менеджера
+type ShutdownReport struct {
+	Flushed   int // Стеков сохранено при остановке
+	Abandoned int // Стеков не успели сохранить до дедлайна
 }
 
 // NavigationState для сериализации в JSON
 type NavigationState struct {
 	UserID    int64     `json:"user_id"`
+	ChatID    int64     `json:"chat_id"`
+	ThreadID  int       `json:"thread_id,omitempty"`
+	MessageID int       `json:"message_id,omitempty"`
 	MenuStack []string  `json:"menu_stack"`
+	Version   int64     `json:"version"`
+	ClickedAt time.Time `json:"clicked_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	Pending   bool      `json:"pending,omitempty"` // Стек ждет записи в очереди, в хранилище его еще нет
 }
 
-func NewPersistentNavigationManager(db *sql.DB) *PersistentNavigationManager {
+// newNavigationState - состояние следующей версии после записи record
+func newNavigationState(scope NavigationScope, record NavigationRecord) NavigationState {
+	return NavigationState{
+		UserID:    scope.UserID,
+		ChatID:    scope.ChatID,
+		ThreadID:  scope.ThreadID,
+		MessageID: scope.MessageID,
+		MenuStack: append([]string(nil), record.Stack...),
+		Version:   record.Version + 1,
+		ClickedAt: record.ClickedAt,
+		UpdatedAt: time.Now(),
+	}
+}
+
+// scope возвращает область, к которой относится состояние
+func (ns NavigationState) scope() NavigationScope {
+	return NavigationScope{UserID: ns.UserID, ChatID: ns.ChatID, ThreadID: ns.ThreadID, MessageID: ns.MessageID}
+}
+
+// record возвращает копию стека с версией
+func (ns NavigationState) record() NavigationRecord {
+	stack := append([]string(nil), ns.MenuStack...)
+	if stack == nil {
+		stack = []string{}
+	}
+	return NavigationRecord{Stack: stack, Version: ns.Version, ClickedAt: ns.ClickedAt}
+}
+
+// NewPersistentNavigationManager создает менеджер поверх PostgreSQL
+// Возвращает ошибку, если таблицу навигации создать не удалось
+func NewPersistentNavigationManager(ctx context.Context, db *sql.DB) (*PersistentNavigationManager, error) {
+	store, err := NewPostgresNavigationStore(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	return NewPersistentNavigationManagerWithStore(store), nil
+}
+
+// NewPersistentNavigationManagerWithStore создает менеджер поверх произвольного хранилища
+func NewPersistentNavigationManagerWithStore(store NavigationStore) *PersistentNavigationManager {
 	selector := &tele.ReplyMarkup{}
-	backBtn := selector.Data("⬅️ Назад", "persistent_back")
+	backBtn := selector.Data("⬅️ Назад", persistentBackUnique)
+
+	breaker := newCircuitBreaker(5, 30*time.Second)
 
 	pnm := &PersistentNavigationManager{
-		db:              db,
-		cache:           make(map[int64][]string),
-		cacheTTL:        make(map[int64]time.Time),
-		backBtn:         backBtn,
-		maxCacheSize:    1000, // Кэшируем только 1000 активных пользователей
-		cacheTimeout:    10 * time.Minute,
-		maxStackDepth:   20,
-		cleanupInterval: 30 * time.Minute,
+		store:            store,
+		breaker:          breaker,
+		writes:           newWriteBehindQueue(store, breaker, 10000),
+		cache:            newNavigationCache(1000, 10*time.Minute), // Кэшируем только 1000 активных пользователей
+		locks:            newUserLocks(),
+		backBtn:          &backBtn,
+		envelope:         newCallbackEnvelope(),
+		maxStackDepth:    20,
+		cleanupInterval:  30 * time.Minute,
+		operationTimeout: 3 * time.Second,
+		instanceID:       newInstanceID(),
+	}
+	pnm.ctx, pnm.stop = context.WithCancel(context.Background())
+	pnm.writes.onSaved = pnm.handleSaved
+	pnm.writes.maxDepth = pnm.maxStackDepth
+
+	retention := DefaultRetentionPolicy()
+	pnm.retention.Store(&retention)
+
+	// Когда хранилище восстановилось, досохраняем накопленные стеки
+	logChange := breaker.onChange
+	breaker.onChange = func(from, to BreakerState) {
+		logChange(from, to)
+		if to == BreakerClosed {
+			pnm.writes.resync()
+		}
 	}
-
-	// Создаем таблицу
-	pnm.createTable()
 
 	// Запускаем фоновые процессы
-	go pnm.cacheCleanupRoutine()
-	go pnm.dbCleanupRoutine()
+	pnm.Start()
 
 	return pnm
 }
 
-// createTable создает таблицу для навигации
-func (pnm *PersistentNavigationManager) createTable() {
-	query := `
-    CREATE TABLE IF NOT EXISTS user_navigation (
-        user_id BIGINT PRIMARY KEY,
-        menu_stack JSONB NOT NULL,
-        updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-    );
+// Start запускает фоновые процессы: запись в хранилище и очистку
+// Вызывается конструктором, повторные вызовы ничего не делают
+func (pnm *PersistentNavigationManager) Start() {
+	pnm.startOnce.Do(func() {
+		pnm.wg.Add(3)
+		go func() {
+			defer pnm.wg.Done()
+			pnm.writes.run(pnm.ctx)
+		}()
+		go pnm.cacheCleanupRoutine()
+		go pnm.dbCleanupRoutine()
+	})
+}
+
+// Close останавливает фоновые процессы и дописывает очередь в хранилище
+// Запись идет до дедлайна ctx, несохраненные стеки попадают в Abandoned
+func (pnm *PersistentNavigationManager) Close(ctx context.Context) (ShutdownReport, error) {
+	var report ShutdownReport
+	var err error
+
+	pnm.closeOnce.Do(func() {
+		// Новые стеки больше не принимаются, остаются только в кэше
+		pnm.writes.close()
+
+		// Отмена прерывает запись пачки и очистку хранилища, их стеки дописывает drain
+		pnm.stop()
+		done := make(chan struct{})
+		go func() {
+			pnm.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+		}
 
-    -- Индекс для быстрой очистки старых записей
-    CREATE INDEX IF NOT EXISTS idx_user_navigation_updated_at 
-    ON user_navigation(updated_at);
-    `
+		report.Flushed, report.Abandoned = pnm.writes.drain(ctx)
 
-	_, err := pnm.db.Exec(query)
+		// Отписываемся после записи - остальные реплики должны узнать о последних стеках
+		if sub := pnm.invalidation.Swap(nil); sub != nil {
+			sub.unsubscribe()
+		}
+		if report.Abandoned > 0 {
+			err = ctx.Err()
+			log.Printf("⚠️  Навигация остановлена: сохранено %d стеков, потеряно %d", report.Flushed, report.Abandoned)
+		} else {
+			log.Printf("✅ Навигация остановлена: сохранено %d стеков", report.Flushed)
+		}
+	})
+
+	return report, err
+}
+
+// newInstanceID - случайный идентификатор реплики для шины инвалидации
+func newInstanceID() string {
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return time.Now().Format("150405.000000000")
+	}
+	return hex.EncodeToString(id)
+}
+
+// SetInvalidationBus подключает шину между репликами бота
+// После записи стека в хранилище другие реплики удаляют пользователя из своего кэша,
+// поэтому клики, попадающие на разные реплики, не видят устаревший стек.
+func (pnm *PersistentNavigationManager) SetInvalidationBus(bus InvalidationBus) error {
+	unsubscribe, err := bus.Subscribe(pnm.handleInvalidation)
 	if err != nil {
-		log.Printf("❌ Ошибка создания таблицы навигации: %v", err)
-	} else {
-		log.Println("✅ Таблица навигации готова")
+		return err
+	}
+
+	if old := pnm.invalidation.Swap(&invalidationSubscription{bus: bus, unsubscribe: unsubscribe}); old != nil {
+		old.unsubscribe()
 	}
+	return nil
+}
+
+// handleSaved обновляет версии в кэше после записи и сообщает другим репликам
+// Если в хранилище оказался более поздний клик, наш стек убирается из кэша
+func (pnm *PersistentNavigationManager) handleSaved(versions map[NavigationScope]int64, superseded []NavigationScope) {
+	for scope, version := range versions {
+		pnm.cache.SetVersion(scope, version)
+	}
+	for _, scope := range superseded {
+		pnm.cache.Remove(scope)
+	}
+
+	sub := pnm.invalidation.Load()
+	if sub == nil || len(versions) == 0 {
+		return
+	}
+
+	scopes := make([]NavigationScope, 0, len(versions))
+	for scope := range versions {
+		scopes = append(scopes, scope)
+	}
+
+	ctx, cancel := pnm.operationContext()
+	defer cancel()
+
+	err := sub.bus.Publish(ctx, Invalidation{Origin: pnm.instanceID, Scopes: scopes})
+	if err != nil {
+		log.Printf("⚠️  Не удалось разослать изменения навигации: %v", err)
+		return
+	}
+	pnm.invalidationsSent.Add(int64(len(scopes)))
+}
+
+// handleInvalidation удаляет из кэша стеки, измененные другой репликой
+func (pnm *PersistentNavigationManager) handleInvalidation(event Invalidation) {
+	if event.Origin == pnm.instanceID {
+		return
+	}
+
+	if event.All {
+		pnm.cache.Clear()
+		log.Printf("🔄 Кэш навигации сброшен: возможно, пропущены изменения других реплик")
+		return
+	}
+
+	for _, scope := range event.Scopes {
+		pnm.cache.Remove(scope)
+	}
+
+	// Данные пользователя удалены на другой реплике - не записываем их обратно из нашей очереди
+	for _, userID := range event.Users {
+		pnm.cache.RemoveUser(userID)
+		pnm.writes.DropUser(userID)
+	}
+	pnm.invalidationsReceived.Add(int64(len(event.Scopes) + len(event.Users)))
+}
+
+// SetRetentionPolicy задает сроки хранения истории для этого бота
+// Применяется со следующего прохода очистки
+func (pnm *PersistentNavigationManager) SetRetentionPolicy(policy RetentionPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	pnm.retention.Store(&policy)
+	return nil
+}
+
+// RetentionPolicy возвращает текущие сроки хранения истории
+func (pnm *PersistentNavigationManager) RetentionPolicy() RetentionPolicy {
+	return *pnm.retention.Load()
+}
+
+// SetOperationTimeout задает таймаут обращения к хранилищу для методов Navigator
+// Методы PushMenu, PopMenu и GetNavigationStats используют context вызывающего кода
+func (pnm *PersistentNavigationManager) SetOperationTimeout(timeout time.Duration) {
+	pnm.operationTimeout = timeout
+}
+
+// operationContext - context для методов Navigator, которые не принимают его сами
+func (pnm *PersistentNavigationManager) operationContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), pnm.operationTimeout)
 }
 
 // PushMenu добавляет меню с гибридным подходом (кэш + БД)
-func (pnm *PersistentNavigationManager) PushMenu(userID int64, menuID string) error {
-	pnm.mutex.Lock()
-	defer pnm.mutex.Unlock()
+func (pnm *PersistentNavigationManager) PushMenu(ctx context.Context, scope NavigationScope, menuID string) error {
+	unlock := pnm.locks.Lock(scope)
+	defer unlock()
 
 	// Получаем текущий стек (из кэша или БД)
-	stack, err := pnm.getStackFromCacheOrDB(userID)
+	record, err := pnm.getStackFromCacheOrDB(ctx, scope)
 	if err != nil {
 		return err
 	}
+	stack := record.Stack
 
 	// Проверяем дубликаты
 	if len(stack) > 0 && stack[len(stack)-1] == menuID {
@@ -101,31 +334,32 @@ func (pnm *PersistentNavigationManager) PushMenu(userID int64, menuID string) er
 		// Удаляем старые элементы
 		keepSize := pnm.maxStackDepth - 5
 		stack = stack[len(stack)-keepSize:]
-		log.Printf("⚠️  User %d: Stack trimmed to %d elements", userID, keepSize)
+		log.Printf("⚠️  %v: Stack trimmed to %d elements", scope, keepSize)
 	}
 
 	// Добавляем новое меню
-	stack = append(stack, menuID)
+	record.Stack = append(stack, menuID)
+	record.ClickedAt = time.Now().UTC()
 
 	// Обновляем кэш
-	pnm.cache[userID] = stack
-	pnm.cacheTTL[userID] = time.Now().Add(pnm.cacheTimeout)
+	pnm.cache.Put(scope, record)
 
-	// Асинхронно сохраняем в БД (не блокируем пользователя)
-	go pnm.saveToDBAsync(userID, stack)
+	// Сохраняем через очередь (не блокируем пользователя)
+	pnm.enqueueSave(scope, record)
 
 	return nil
 }
 
 // PopMenu убирает последнее меню
-func (pnm *PersistentNavigationManager) PopMenu(userID int64) (string, bool, error) {
-	pnm.mutex.Lock()
-	defer pnm.mutex.Unlock()
+func (pnm *PersistentNavigationManager) PopMenu(ctx context.Context, scope NavigationScope) (string, bool, error) {
+	unlock := pnm.locks.Lock(scope)
+	defer unlock()
 
-	stack, err := pnm.getStackFromCacheOrDB(userID)
+	record, err := pnm.getStackFromCacheOrDB(ctx, scope)
 	if err != nil {
 		return "", false, err
 	}
+	stack := record.Stack
 
 	if len(stack) <= 1 {
 		return "", false, nil
@@ -134,84 +368,102 @@ func (pnm *PersistentNavigationManager) PopMenu(userID int64) (string, bool, err
 	// Убираем последний элемент
 	stack = stack[:len(stack)-1]
 	prevMenu := stack[len(stack)-1]
+	record.Stack = stack
+	record.ClickedAt = time.Now().UTC()
 
 	// Обновляем кэш
-	pnm.cache[userID] = stack
-	pnm.cacheTTL[userID] = time.Now().Add(pnm.cacheTimeout)
+	pnm.cache.Put(scope, record)
 
-	// Асинхронно сохраняем в БД
-	go pnm.saveToDBAsync(userID, stack)
+	// Сохраняем через очередь
+	pnm.enqueueSave(scope, record)
 
 	return prevMenu, true, nil
 }
 
-// getStackFromCacheOrDB получает стек из кэша или БД
-func (pnm *PersistentNavigationManager) getStackFromCacheOrDB(userID int64) ([]string, error) {
-	// Проверяем кэш
-	if stack, exists := pnm.cache[userID]; exists {
-		if ttl, hasTTL := pnm.cacheTTL[userID]; hasTTL && time.Now().Before(ttl) {
-			return stack, nil
-		}
-		// TTL истек, удаляем из кэша
-		delete(pnm.cache, userID)
-		delete(pnm.cacheTTL, userID)
+// getStackFromCacheOrDB получает стек с версией из кэша или БД
+// Вызывается под блокировкой пользователя
+func (pnm *PersistentNavigationManager) getStackFromCacheOrDB(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	// Проверяем кэш (устаревшая запись удаляется при чтении)
+	if record, exists := pnm.cache.Get(scope); exists {
+		return record, nil
 	}
 
-	// Загружаем из БД
-	return pnm.loadFromDB(userID)
+	// Стек мог вытесниться из кэша, пока ждет записи - он новее, чем в хранилище
+	if record, exists := pnm.writes.Peek(scope); exists {
+		record.Stack = append([]string(nil), record.Stack...)
+		pnm.cache.Put(scope, record)
+		return record, nil
+	}
+
+	// Загружаем из хранилища
+	return pnm.loadFromDB(ctx, scope)
 }
 
-// loadFromDB загружает навигацию из БД
-func (pnm *PersistentNavigationManager) loadFromDB(userID int64) ([]string, error) {
-	var stackJSON []byte
-	query := "SELECT menu_stack FROM user_navigation WHERE user_id = $1"
+// loadFromDB загружает навигацию из хранилища
+// Для нового пользователя возвращает пустой стек, остальные ошибки - StoreError.
+// Пока предохранитель открыт, навигация начинается с временного стека только в памяти;
+// единичный сбой хранилища возвращается ошибкой, чтобы не подменять историю пустым стеком.
+func (pnm *PersistentNavigationManager) loadFromDB(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	if !pnm.breaker.Allow() {
+		return pnm.degradedStack(scope, storeUnavailable("load", scope, ErrCircuitOpen)), nil
+	}
+
+	record, err := pnm.store.Load(ctx, scope)
+	pnm.breaker.Record(err)
 
-	err := pnm.db.QueryRow(query, userID).Scan(&stackJSON)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return []string{}, nil // Новый пользователь
+	switch {
+	case errors.Is(err, ErrNavigationNotFound):
+		record, err = NavigationRecord{Stack: []string{}}, nil
+
+		// Сообщение отправлено до привязки истории к сообщениям - продолжаем общую историю чата
+		if scope.MessageID != 0 {
+			if shared, loadErr := pnm.store.Load(ctx, scope.Message(0)); loadErr == nil {
+				record.Stack = shared.Stack
+			}
 		}
-		return nil, err
+	case errors.Is(err, ErrStoreUnavailable) && ctx.Err() == nil && pnm.breaker.State() == BreakerOpen:
+		return pnm.degradedStack(scope, err), nil
 	}
-
-	var stack []string
-	err = json.Unmarshal(stackJSON, &stack)
 	if err != nil {
-		return nil, err
+		return NavigationRecord{}, err
 	}
 
 	// Добавляем в кэш после загрузки
-	pnm.cache[userID] = stack
-	pnm.cacheTTL[userID] = time.Now().Add(pnm.cacheTimeout)
+	pnm.cache.Put(scope, record)
 
-	return stack, nil
+	return record, nil
 }
 
-// saveToDBAsync асинхронно сохраняет в БД
-func (pnm *PersistentNavigationManager) saveToDBAsync(userID int64, stack []string) {
-	stackJSON, err := json.Marshal(stack)
-	if err != nil {
-		log.Printf("❌ Ошибка сериализации стека для user %d: %v", userID, err)
-		return
-	}
+// degradedStack - временный пустой стек в кэше вместо недоступного хранилища
+// Стек попадает в очередь записи при первом клике и досохранится после восстановления:
+// версия 0 даст конфликт, и клики временного стека допишутся к сохраненной истории.
+func (pnm *PersistentNavigationManager) degradedStack(scope NavigationScope, cause error) NavigationRecord {
+	log.Printf("⚠️  Навигация %v только в памяти: %v", scope, cause)
 
-	query := `
-    INSERT INTO user_navigation (user_id, menu_stack, updated_at) 
-    VALUES ($1, $2, CURRENT_TIMESTAMP) 
-    ON CONFLICT (user_id) 
-    DO UPDATE SET 
-        menu_stack = EXCLUDED.menu_stack,
-        updated_at = CURRENT_TIMESTAMP
-    `
+	record := NavigationRecord{Stack: []string{}, Degraded: true}
+	pnm.cache.Put(scope, record)
+	return record
+}
 
-	_, err = pnm.db.Exec(query, userID, stackJSON)
-	if err != nil {
-		log.Printf("❌ Ошибка сохранения навигации для user %d: %v", userID, err)
+// BreakerState возвращает состояние предохранителя хранилища
+// BreakerOpen означает, что навигация работает только в памяти
+func (pnm *PersistentNavigationManager) BreakerState() BreakerState {
+	return pnm.breaker.State()
+}
+
+// enqueueSave ставит копию стека в очередь записи
+// Ставится под блокировкой пользователя, чтобы порядок в очереди совпадал с порядком кликов
+func (pnm *PersistentNavigationManager) enqueueSave(scope NavigationScope, record NavigationRecord) {
+	record.Stack = append([]string(nil), record.Stack...)
+	if err := pnm.writes.Enqueue(scope, record); err != nil {
+		log.Printf("⚠️  Навигация %v сохранена только в кэше: %v", scope, err)
 	}
 }
 
 // cacheCleanupRoutine очищает кэш от устаревших данных
 func (pnm *PersistentNavigationManager) cacheCleanupRoutine() {
+	defer pnm.wg.Done()
+
 	ticker := time.NewTicker(5 * time.Minute)
 	defer ticker.Stop()
 
@@ -219,40 +471,16 @@ func (pnm *PersistentNavigationManager) cacheCleanupRoutine() {
 		select {
 		case <-ticker.C:
 			pnm.cleanupCache()
+		case <-pnm.ctx.Done():
+			return
 		}
 	}
 }
 
 // cleanupCache удаляет устаревшие элементы из кэша
+// Размер кэша ограничивается сразу при вставке, здесь освобождается только память истекших записей
 func (pnm *PersistentNavigationManager) cleanupCache() {
-	pnm.mutex.Lock()
-	defer pnm.mutex.Unlock()
-
-	now := time.Now()
-	var cleaned int
-
-	for userID, ttl := range pnm.cacheTTL {
-		if now.After(ttl) {
-			delete(pnm.cache, userID)
-			delete(pnm.cacheTTL, userID)
-			cleaned++
-		}
-	}
-
-	// Если кэш слишком большой, удаляем самые старые элементы
-	if len(pnm.cache) > pnm.maxCacheSize {
-		// Простая стратегия: удаляем случайные элементы
-		excess := len(pnm.cache) - pnm.maxCacheSize + 100 // +100 для буфера
-		for userID := range pnm.cache {
-			if excess <= 0 {
-				break
-			}
-			delete(pnm.cache, userID)
-			delete(pnm.cacheTTL, userID)
-			excess--
-			cleaned++
-		}
-	}
+	cleaned := pnm.cache.Expire()
 
 	if cleaned > 0 {
 		log.Printf("🧹 Очищено %d элементов из кэша навигации", cleaned)
@@ -261,78 +489,217 @@ func (pnm *PersistentNavigationManager) cleanupCache() {
 
 // dbCleanupRoutine удаляет старые записи из БД
 func (pnm *PersistentNavigationManager) dbCleanupRoutine() {
+	defer pnm.wg.Done()
+
 	ticker := time.NewTicker(pnm.cleanupInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ticker.C:
-			pnm.cleanupOldNavigationData(24 * time.Hour) // Удаляем данные старше суток
+			ctx, cancel := context.WithTimeout(pnm.ctx, time.Minute)
+			affected, err := pnm.cleanupOldNavigationData(ctx, pnm.RetentionPolicy())
+			cancel()
+
+			if err != nil {
+				log.Printf("❌ Ошибка очистки старых данных навигации: %v", err)
+			} else if affected > 0 {
+				log.Printf("🧹 Удалено %d старых записей навигации", affected)
+			}
+		case <-pnm.ctx.Done():
+			return
 		}
 	}
 }
 
-// cleanupOldNavigationData удаляет старые данные навигации
-func (pnm *PersistentNavigationManager) cleanupOldNavigationData(maxAge time.Duration) {
-	query := "DELETE FROM user_navigation WHERE updated_at < $1"
-	cutoff := time.Now().Add(-maxAge)
-
-	result, err := pnm.db.Exec(query, cutoff)
-	if err != nil {
-		log.Printf("❌ Ошибка очистки старых данных навигации: %v", err)
-		return
+// cleanupOldNavigationData удаляет данные с истекшим сроком хранения и возвращает количество удаленных записей
+func (pnm *PersistentNavigationManager) cleanupOldNavigationData(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if !pnm.breaker.Allow() {
+		return 0, storeUnavailable("cleanup", NavigationScope{}, ErrCircuitOpen)
 	}
 
-	affected, _ := result.RowsAffected()
-	if affected > 0 {
-		log.Printf("🧹 Удалено %d старых записей навигации", affected)
-	}
+	affected, err := pnm.store.Cleanup(ctx, policy)
+	pnm.breaker.Record(err)
+	return affected, err
 }
 
 // GetNavigationStats возвращает статистику
-func (pnm *PersistentNavigationManager) GetNavigationStats() (map[string]interface{}, error) {
-	pnm.mutex.RLock()
-	cacheSize := len(pnm.cache)
-	pnm.mutex.RUnlock()
-
-	// Статистика из БД
-	var dbRecords int
-	var avgDepth float64
-	var maxDepth int
-
-	query := `
-    SELECT 
-        COUNT(*) as total_records,
-        AVG(jsonb_array_length(menu_stack)) as avg_depth,
-        MAX(jsonb_array_length(menu_stack)) as max_depth
-    FROM user_navigation
-    `
-
-	err := pnm.db.QueryRow(query).Scan(&dbRecords, &avgDepth, &maxDepth)
-	if err != nil {
-		return nil, err
+// Пока хранилище недоступно, возвращается статистика кэша, очереди и предохранителя без db_*
+func (pnm *PersistentNavigationManager) GetNavigationStats(ctx context.Context) (map[string]interface{}, error) {
+	result := map[string]interface{}{
+		"max_allowed_depth": pnm.maxStackDepth,
+		"locked_users":      pnm.locks.Len(),
 	}
 
-	return map[string]interface{}{
-		"cache_size":        cacheSize,
-		"max_cache_size":    pnm.maxCacheSize,
-		"db_records":        dbRecords,
-		"average_depth":     avgDepth,
-		"max_depth":         maxDepth,
-		"max_allowed_depth": pnm.maxStackDepth,
-		"cache_timeout_min": pnm.cacheTimeout.Minutes(),
-	}, nil
+	// Статистика из хранилища
+	if pnm.breaker.Allow() {
+		stats, err := pnm.store.Stats(ctx)
+		pnm.breaker.Record(err)
+
+		switch {
+		case err == nil:
+			result["db_records"] = stats.Records
+			result["average_depth"] = stats.AvgDepth
+			result["max_depth"] = stats.MaxDepth
+		case errors.Is(err, ErrStoreUnavailable):
+			result["store_error"] = err.Error()
+		default:
+			return nil, err
+		}
+	}
+
+	result["invalidations_sent"] = pnm.invalidationsSent.Load()
+	result["invalidations_received"] = pnm.invalidationsReceived.Load()
+
+	for key, value := range pnm.breaker.Stats() {
+		result[key] = value
+	}
+	for key, value := range pnm.cache.Stats() {
+		result[key] = value
+	}
+	for key, value := range pnm.writes.Stats() {
+		result[key] = value
+	}
+	return result, nil
+}
+
+// SetCallbackVersions задает версионирование callback_data и перенаправления меню
+func (pnm *PersistentNavigationManager) SetCallbackVersions(versions *CallbackVersions) {
+	pnm.envelope.versions = versions
 }
 
-// GetBackButton возвращает кнопку назад
+// GetBackButton возвращает кнопку назад для регистрации обработчика
+// Так приходят кнопки без обертки из старых сообщений, на экранах используется createBackButton
 func (pnm *PersistentNavigationManager) GetBackButton() *tele.Btn {
 	return pnm.backBtn
 }
 
+// createBackButton создает кнопку "назад" в обертке версии callback_data: "v2:persistent_back"
+func (pnm *PersistentNavigationManager) createBackButton() *tele.Btn {
+	selector := &tele.ReplyMarkup{}
+	btn := selector.Data(pnm.backBtn.Text, pnm.envelope.wrap(persistentBackUnique))
+	return &btn
+}
+
 // AddBackButton добавляет кнопку к клавиатуре
 func (pnm *PersistentNavigationManager) AddBackButton(keyboard *tele.ReplyMarkup) {
-	if keyboard.InlineKeyboard == nil {
-		keyboard.InlineKeyboard = make([][]tele.Btn, 0)
+	appendButtonRow(keyboard, pnm.createBackButton())
+}
+
+// MenuButton реализует Navigator: стек хранится в БД, в кнопке только меню
+func (pnm *PersistentNavigationManager) MenuButton(from Screen, text, menuID string) *tele.Btn {
+	selector := &tele.ReplyMarkup{}
+	btn := selector.Data(text, pnm.envelope.wrap("menu:"+menuID))
+	return &btn
+}
+
+// BackButton реализует Navigator: кнопка есть, если в стеке пользователя больше одного меню
+func (pnm *PersistentNavigationManager) BackButton(screen Screen) *tele.Btn {
+	ctx, cancel := pnm.operationContext()
+	defer cancel()
+
+	unlock := pnm.locks.Lock(screen.Scope)
+	record, err := pnm.getStackFromCacheOrDB(ctx, screen.Scope)
+	unlock()
+
+	if err != nil {
+		log.Printf("❌ Ошибка загрузки навигации для %v: %v", screen.Scope, err)
+		return pnm.createBackButton()
+	}
+
+	if len(record.Stack) <= 1 {
+		return nil
+	}
+	return pnm.createBackButton()
+}
+
+// Open реализует Navigator: добавляет меню в стек пользователя
+func (pnm *PersistentNavigationManager) Open(screen Screen) error {
+	ctx, cancel := pnm.operationContext()
+	defer cancel()
+
+	return pnm.PushMenu(ctx, screen.Scope, screen.MenuID)
+}
+
+// BindMessage реализует MessageBinder: история, собранная до отправки меню, переходит к сообщению
+// Область без сообщения очищается, чтобы следующее отправленное меню начало свою историю
+func (pnm *PersistentNavigationManager) BindMessage(screen Screen, messageID int) error {
+	ctx, cancel := pnm.operationContext()
+	defer cancel()
+
+	draft := screen.Scope.Message(0)
+	unlock := pnm.locks.Lock(draft)
+	record, err := pnm.getStackFromCacheOrDB(ctx, draft)
+	if err != nil {
+		unlock()
+		return err
+	}
+
+	stack := append([]string(nil), record.Stack...)
+	if len(record.Stack) > 0 {
+		record.Stack = []string{}
+		record.ClickedAt = time.Now().UTC()
+		pnm.cache.Put(draft, record)
+		pnm.enqueueSave(draft, record)
 	}
-	keyboard.InlineKeyboard = append(keyboard.InlineKeyboard, []tele.Btn{*pnm.backBtn})
+	unlock()
+
+	// Сообщение показывает screen - его история заканчивается этим меню
+	if len(stack) == 0 || stack[len(stack)-1] != screen.MenuID {
+		stack = append(stack, screen.MenuID)
+	}
+
+	// ID сообщения новый, записи для него в хранилище еще нет - версия 0
+	bound := draft.Message(messageID)
+	unlock = pnm.locks.Lock(bound)
+	defer unlock()
+
+	record = NavigationRecord{Stack: stack, ClickedAt: time.Now().UTC()}
+	pnm.cache.Put(bound, record)
+	pnm.enqueueSave(bound, record)
+	return nil
+}
+
+// Back реализует Navigator: снимает меню со стека пользователя
+func (pnm *PersistentNavigationManager) Back(screen Screen) (Screen, bool, error) {
+	ctx, cancel := pnm.operationContext()
+	defer cancel()
+
+	prevMenu, ok, err := pnm.PopMenu(ctx, screen.Scope)
+	if err != nil || !ok {
+		return Screen{}, false, err
+	}
+	return Screen{Scope: screen.Scope, MenuID: prevMenu}, true, nil
+}
+
+// Resolve реализует Navigator: "persistent_back" снимает меню со стека, "menu:" открывает меню
+func (pnm *PersistentNavigationManager) Resolve(scope NavigationScope, callbackData string) (Screen, bool, error) {
+	data := normalizeCallbackData(callbackData)
+
+	if pnm.envelope.peek(data) == persistentBackUnique {
+		if _, err := pnm.envelope.unwrap(data); err != nil {
+			return Screen{}, true, err
+		}
+		prev, ok, err := pnm.Back(Screen{Scope: scope})
+		if err != nil {
+			return Screen{}, true, err
+		}
+		if !ok {
+			// Стек пуст - возвращаемся в главное меню
+			return Screen{Scope: scope, MenuID: "main"}, true, nil
+		}
+		prev.MenuID = pnm.envelope.migrateMenu(prev.MenuID)
+		return prev, true, nil
+	}
+
+	if strings.HasPrefix(pnm.envelope.peek(data), "menu:") {
+		payload, err := pnm.envelope.unwrap(data)
+		if err != nil {
+			return Screen{}, true, err
+		}
+		menuID := pnm.envelope.migrateMenu(strings.TrimPrefix(payload, "menu:"))
+		return Screen{Scope: scope, MenuID: menuID}, true, nil
+	}
+
+	return Screen{}, false, nil
 }
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetentionPolicy - сколько хранить историю навигации, не обновлявшуюся с последнего клика
+// Ноль у отдельного вида области означает срок Default.
+type RetentionPolicy struct {
+	Default time.Duration // Срок для областей без своего правила
+	Private time.Duration // Личный чат с ботом
+	Group   time.Duration // Группы и темы форума
+	Message time.Duration // История отдельного сообщения с меню (в любом чате)
+}
+
+// DefaultRetentionPolicy - история хранится сутки
+func DefaultRetentionPolicy() RetentionPolicy {
+	return RetentionPolicy{Default: 24 * time.Hour}
+}
+
+// Validate проверяет, что сроки положительные
+func (rp RetentionPolicy) Validate() error {
+	if rp.Default <= 0 {
+		return fmt.Errorf("default retention must be positive, got %v", rp.Default)
+	}
+	if rp.Private < 0 || rp.Group < 0 || rp.Message < 0 {
+		return fmt.Errorf("retention must not be negative")
+	}
+	return nil
+}
+
+// MaxAge возвращает срок хранения истории области scope
+// История сообщения подчиняется правилу Message, даже если сообщение в группе
+func (rp RetentionPolicy) MaxAge(scope NavigationScope) time.Duration {
+	switch {
+	case scope.MessageID != 0:
+		return rp.or(rp.Message)
+	case scope.ChatID != scope.UserID:
+		return rp.or(rp.Group)
+	}
+	return rp.or(rp.Private)
+}
+
+// Cutoffs возвращает границы времени обновления для личных чатов, групп и сообщений
+// Записи, обновленные раньше границы своего вида, подлежат удалению
+func (rp RetentionPolicy) Cutoffs(now time.Time) (private, group, message time.Time) {
+	return now.Add(-rp.or(rp.Private)), now.Add(-rp.or(rp.Group)), now.Add(-rp.or(rp.Message))
+}
+
+// Expired сообщает, истек ли срок хранения истории scope, обновленной в updatedAt
+func (rp RetentionPolicy) Expired(scope NavigationScope, updatedAt, now time.Time) bool {
+	return updatedAt.Before(now.Add(-rp.MaxAge(scope)))
+}
+
+func (rp RetentionPolicy) or(maxAge time.Duration) time.Duration {
+	if maxAge > 0 {
+		return maxAge
+	}
+	return rp.Default
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+
+	tele "gopkg.in/telebot.v3"
+)
+
+// NavigationScope - область, в которой хранится своя история навигации
+// Один пользователь в личке, в группе и в разных темах форума видит независимые стеки,
+// поэтому кнопка "назад" не перескакивает между несвязанными разговорами.
+// Каждое сообщение с меню тоже навигирует независимо: кнопка "назад" в старом
+// сообщении снимает историю этого сообщения, а не последнего открытого меню.
+type NavigationScope struct {
+	UserID    int64
+	ChatID    int64
+	ThreadID  int // message_thread_id темы форума, 0 - чат без тем
+	MessageID int // Сообщение с клавиатурой, 0 - меню еще не отправлено
+}
+
+// UserScope - область личного чата с ботом (ID чата совпадает с ID пользователя)
+func UserScope(userID int64) NavigationScope {
+	return NavigationScope{UserID: userID, ChatID: userID}
+}
+
+// ScopeFromContext определяет область по апдейту telebot
+func ScopeFromContext(c tele.Context) NavigationScope {
+	scope := NavigationScope{}
+	if sender := c.Sender(); sender != nil {
+		scope.UserID = sender.ID
+	}
+
+	if chat := c.Chat(); chat != nil {
+		scope.ChatID = chat.ID
+	} else {
+		scope.ChatID = scope.UserID
+	}
+
+	if msg := c.Message(); msg != nil && msg.TopicMessage {
+		scope.ThreadID = msg.ThreadID
+	}
+
+	// Клавиатура есть только у сообщения бота, на котором нажата кнопка
+	if cb := c.Callback(); cb != nil && cb.Message != nil {
+		scope.MessageID = cb.Message.ID
+	}
+	return scope
+}
+
+// Message возвращает ту же область, привязанную к сообщению messageID
+func (ns NavigationScope) Message(messageID int) NavigationScope {
+	ns.MessageID = messageID
+	return ns
+}
+
+// String используется в логах
+func (ns NavigationScope) String() string {
+	if ns.MessageID != 0 {
+		return fmt.Sprintf("%v message %d", ns.Message(0), ns.MessageID)
+	}
+
+	switch {
+	case ns.ThreadID != 0:
+		return fmt.Sprintf("user %d chat %d topic %d", ns.UserID, ns.ChatID, ns.ThreadID)
+	case ns.ChatID != ns.UserID && ns.ChatID != 0:
+		return fmt.Sprintf("user %d chat %d", ns.UserID, ns.ChatID)
+	}
+	return fmt.Sprintf("user %d", ns.UserID)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// sendMenu повторяет отправку нового меню в Show: экран открывается без сообщения и привязывается к нему
+func sendMenu(t *testing.T, pnm *PersistentNavigationManager, scope NavigationScope, menuID string, messageID int) {
+	t.Helper()
+
+	screen := Screen{Scope: scope, MenuID: menuID}
+	if err := pnm.Open(screen); err != nil {
+		t.Fatal(err)
+	}
+	if err := pnm.BindMessage(screen, messageID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBindMessageKeepsHistoryPerMessage(t *testing.T) {
+	ctx := context.Background()
+	pnm := NewPersistentNavigationManagerWithStore(NewMemoryNavigationStore())
+	t.Cleanup(func() { pnm.Close(context.Background()) })
+
+	registry := NewMenuRegistry("main")
+	if err := registry.Register(
+		&Menu{ID: "main", Title: "Главное"},
+		&Menu{ID: "settings", Title: "Настройки", Parent: "main"},
+		&Menu{ID: "privacy", Title: "Приватность", Parent: "settings"},
+		&Menu{ID: "help", Title: "Помощь", Parent: "main"},
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	click := func(messageID int, data string) {
+		t.Helper()
+		if err := registry.Dispatch(newCallbackContext(1, messageID, data), pnm); err != nil {
+			t.Fatalf("click on message %d: %v", messageID, err)
+		}
+	}
+	open := func(menuID string) string {
+		return pnm.MenuButton(Screen{}, menuID, menuID).Inline().Data
+	}
+	stack := func(scope NavigationScope) []string {
+		t.Helper()
+		record, err := pnm.getStackFromCacheOrDB(ctx, scope)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record.Stack
+	}
+
+	user := UserScope(1)
+
+	// Старое меню: main -> settings -> privacy
+	sendMenu(t, pnm, user, "main", 100)
+	click(100, open("settings"))
+	click(100, open("privacy"))
+
+	// Новое меню того же пользователя начинает свою историю
+	sendMenu(t, pnm, user, "main", 200)
+	click(200, open("help"))
+
+	if got := stack(user.Message(0)); len(got) != 0 {
+		t.Errorf("draft stack = %v, want empty after binding", got)
+	}
+	if got := stack(user.Message(200)); !sameMenus(got, []string{"main", "help"}) {
+		t.Fatalf("new message stack = %v", got)
+	}
+
+	// "Назад" на старом сообщении снимает только его историю
+	click(100, pnm.createBackButton().Inline().Data)
+
+	if got := stack(user.Message(100)); !sameMenus(got, []string{"main", "settings"}) {
+		t.Errorf("old message stack after back = %v, want [main settings]", got)
+	}
+	if got := stack(user.Message(200)); !sameMenus(got, []string{"main", "help"}) {
+		t.Errorf("new message stack after back on the old one = %v, want [main help]", got)
+	}
+}
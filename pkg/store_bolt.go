@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// navigationBucket - bucket со стеками навигации бота по умолчанию
+// У остальных ботов свой bucket "user_navigation:<бот>", см. WithBot
+var navigationBucket = []byte("user_navigation")
+
+// BoltNavigationStore - встроенное файловое хранилище стеков (bbolt)
+// Не требует отдельного сервера БД, переживает рестарт бота
+type BoltNavigationStore struct {
+	db     *bolt.DB
+	bucket []byte // Bucket стеков бота, создается при первой записи
+}
+
+func NewBoltNavigationStore(path string) (*BoltNavigationStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(navigationBucket)
+		if err != nil {
+			return err
+		}
+		return migrateBoltKeys(bucket)
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltNavigationStore{db: db, bucket: navigationBucket}, nil
+}
+
+// WithBot возвращает хранилище стеков бота bot в том же файле
+// Чтение, запись, очистка по сроку хранения и удаление пользователя не затрагивают другие боты.
+func (bns *BoltNavigationStore) WithBot(bot string) *BoltNavigationStore {
+	bucket := navigationBucket
+	if bot != "" {
+		bucket = []byte(string(navigationBucket) + ":" + bot)
+	}
+	return &BoltNavigationStore{db: bns.db, bucket: bucket}
+}
+
+// Close закрывает файл хранилища, общий для всех ботов WithBot
+func (bns *BoltNavigationStore) Close() error {
+	return bns.db.Close()
+}
+
+// boltKeySize - ключ user|chat|thread|message, по 8 байт на поле
+const boltKeySize = 32
+
+// boltKey кодирует scope в ключ user|chat|thread|message с сохранением порядка
+// Все области одного пользователя лежат в bucket подряд
+func boltKey(scope NavigationScope) []byte {
+	key := make([]byte, boltKeySize)
+	binary.BigEndian.PutUint64(key, uint64(scope.UserID))
+	binary.BigEndian.PutUint64(key[8:], uint64(scope.ChatID))
+	binary.BigEndian.PutUint64(key[16:], uint64(int64(scope.ThreadID)))
+	binary.BigEndian.PutUint64(key[24:], uint64(int64(scope.MessageID)))
+	return key
+}
+
+// scopeFromBoltKey - обратное преобразование
+// Старые ключи короче: 8 байт - личный чат, 24 байта - чат и тема без сообщения
+func scopeFromBoltKey(key []byte) NavigationScope {
+	field := func(i int) int64 {
+		return int64(binary.BigEndian.Uint64(key[i*8:]))
+	}
+
+	switch len(key) {
+	case 8:
+		return UserScope(field(0))
+	case 24:
+		return NavigationScope{UserID: field(0), ChatID: field(1), ThreadID: int(field(2))}
+	case boltKeySize:
+		return NavigationScope{UserID: field(0), ChatID: field(1), ThreadID: int(field(2)), MessageID: int(field(3))}
+	}
+	return NavigationScope{}
+}
+
+// migrateBoltKeys переносит записи со старыми ключами в формат boltKey
+func migrateBoltKeys(bucket *bolt.Bucket) error {
+	var legacy [][]byte
+	err := bucket.ForEach(func(key, data []byte) error {
+		if len(key) == 8 || len(key) == 24 {
+			legacy = append(legacy, append([]byte(nil), key...))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// Переносим после обхода - менять bucket во время ForEach нельзя
+	for _, key := range legacy {
+		data := append([]byte(nil), bucket.Get(key)...)
+		if err := bucket.Put(boltKey(scopeFromBoltKey(key)), data); err != nil {
+			return err
+		}
+		if err := bucket.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bbolt не принимает context, поэтому отмена проверяется перед транзакцией
+
+// Load загружает стек из файла
+func (bns *BoltNavigationStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	if err := ctx.Err(); err != nil {
+		return NavigationRecord{}, storeUnavailable("load", scope, err)
+	}
+
+	var data []byte
+	err := bns.db.View(func(tx *bolt.Tx) error {
+		// Данные валидны только внутри транзакции - копируем
+		if bucket := tx.Bucket(bns.bucket); bucket != nil {
+			data = append([]byte(nil), bucket.Get(boltKey(scope))...)
+		}
+		return nil
+	})
+	if err != nil {
+		return NavigationRecord{}, storeUnavailable("load", scope, err)
+	}
+
+	if len(data) == 0 {
+		return NavigationRecord{}, storeNotFound("load", scope) // Новый пользователь
+	}
+
+	var state NavigationState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return NavigationRecord{}, storeCorrupt("load", scope, err)
+	}
+	return state.record(), nil
+}
+
+// Save сохраняет стек в файл, если версия в файле не изменилась
+// Проверка и запись идут в одной транзакции bbolt
+func (bns *BoltNavigationStore) Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("save", scope, err)
+	}
+
+	data, err := json.Marshal(newNavigationState(scope, record))
+	if err != nil {
+		return 0, storeCorrupt("save", scope, err)
+	}
+
+	err = bns.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(bns.bucket)
+		if err != nil {
+			return err
+		}
+
+		// Поврежденная запись считается версией 0 и перезаписывается
+		var current NavigationState
+		if existing := bucket.Get(boltKey(scope)); existing != nil {
+			json.Unmarshal(existing, &current)
+		}
+		if current.Version != record.Version {
+			return storeConflict("save", scope)
+		}
+
+		return bucket.Put(boltKey(scope), data)
+	})
+	if errors.Is(err, ErrVersionConflict) {
+		return 0, err
+	}
+	if err != nil {
+		return 0, storeUnavailable("save", scope, err)
+	}
+	return record.Version + 1, nil
+}
+
+// Delete удаляет стек пользователя
+func (bns *BoltNavigationStore) Delete(ctx context.Context, scope NavigationScope) error {
+	if err := ctx.Err(); err != nil {
+		return storeUnavailable("delete", scope, err)
+	}
+
+	err := bns.db.Update(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket(bns.bucket); bucket != nil {
+			return bucket.Delete(boltKey(scope))
+		}
+		return nil
+	})
+	return storeUnavailable("delete", scope, err)
+}
+
+// Cleanup удаляет устаревшие стеки бота полным проходом по его bucket
+func (bns *BoltNavigationStore) Cleanup(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("cleanup", NavigationScope{}, err)
+	}
+
+	now := time.Now()
+	var removed int64
+
+	err := bns.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bns.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		var stale [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var state NavigationState
+			if err := json.Unmarshal(data, &state); err != nil || policy.Expired(scopeFromBoltKey(key), state.UpdatedAt, now) {
+				stale = append(stale, append([]byte(nil), key...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		// Удаляем после обхода - менять bucket во время ForEach нельзя
+		for _, key := range stale {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+
+	return removed, storeUnavailable("cleanup", NavigationScope{}, err)
+}
+
+// userPrefix - начало ключей всех областей пользователя
+func userPrefix(userID int64) []byte {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, uint64(userID))
+	return prefix
+}
+
+// ExportUser возвращает все стеки пользователя, ключи пользователя лежат в bucket подряд
+func (bns *BoltNavigationStore) ExportUser(ctx context.Context, userID int64) ([]NavigationState, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+	}
+
+	var states []NavigationState
+	prefix := userPrefix(userID)
+
+	err := bns.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bns.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, data := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, data = cursor.Next() {
+			scope := scopeFromBoltKey(key)
+
+			var state NavigationState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return storeCorrupt("export", scope, err)
+			}
+
+			// Область берем из ключа: в записях до миграции ключей ее нет
+			state.UserID, state.ChatID, state.ThreadID, state.MessageID = scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID
+			states = append(states, state)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, ErrCorruptStack) {
+		err = storeUnavailable("export", NavigationScope{UserID: userID}, err)
+	}
+	return states, err
+}
+
+// EraseUser удаляет все стеки пользователя
+func (bns *BoltNavigationStore) EraseUser(ctx context.Context, userID int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+	}
+
+	var removed int64
+	prefix := userPrefix(userID)
+
+	err := bns.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bns.bucket)
+		if bucket == nil {
+			return nil
+		}
+
+		cursor := bucket.Cursor()
+		for key, _ := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); {
+			// После Delete курсор может пропустить ключ, поэтому ищем начало заново
+			if err := cursor.Delete(); err != nil {
+				return err
+			}
+			removed++
+			key, _ = cursor.Seek(prefix)
+		}
+		return nil
+	})
+	return removed, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+}
+
+// Stats считает статистику полным проходом по bucket
+func (bns *BoltNavigationStore) Stats(ctx context.Context) (StoreStats, error) {
+	if err := ctx.Err(); err != nil {
+		return StoreStats{}, storeUnavailable("stats", NavigationScope{}, err)
+	}
+
+	var stats StoreStats
+	var totalDepth int
+
+	err := bns.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bns.bucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(key, data []byte) error {
+			var state NavigationState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return storeCorrupt("stats", scopeFromBoltKey(key), err)
+			}
+
+			depth := len(state.MenuStack)
+			totalDepth += depth
+			if depth > stats.MaxDepth {
+				stats.MaxDepth = depth
+			}
+			stats.Records++
+			return nil
+		})
+	})
+
+	if stats.Records > 0 {
+		stats.AvgDepth = float64(totalDepth) / float64(stats.Records)
+	}
+	if err != nil && !errors.Is(err, ErrCorruptStack) {
+		err = storeUnavailable("stats", NavigationScope{}, err)
+	}
+	return stats, err
+}
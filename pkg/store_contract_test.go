@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Каждое хранилище навигации проходит один и тот же набор проверок NavigationStore
+func TestNavigationStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) NavigationStore{
+		"memory": func(t *testing.T) NavigationStore {
+			return NewMemoryNavigationStore()
+		},
+		"bolt": func(t *testing.T) NavigationStore {
+			store, err := NewBoltNavigationStore(filepath.Join(t.TempDir(), "navigation.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store
+		},
+		"postgres": func(t *testing.T) NavigationStore {
+			store, err := NewPostgresNavigationStore(context.Background(), openTestDB(t, "postgres"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+		"sqlite": func(t *testing.T) NavigationStore {
+			store, err := NewSQLiteNavigationStore(context.Background(), openTestDB(t, "sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			testNavigationStoreContract(t, newStore(t))
+		})
+	}
+}
+
+func testNavigationStoreContract(t *testing.T, store NavigationStore) {
+	ctx := context.Background()
+	clicked := time.Now().UTC().Truncate(time.Second)
+	private := UserScope(1)
+	message := NavigationScope{UserID: 1, ChatID: -100, ThreadID: 3, MessageID: 42}
+
+	if _, err := store.Load(ctx, private); !errors.Is(err, ErrNavigationNotFound) {
+		t.Fatalf("load new user = %v, want ErrNavigationNotFound", err)
+	}
+
+	// Новая запись - версия 0, каждая запись увеличивает версию
+	version, err := store.Save(ctx, private, NavigationRecord{Stack: []string{"main"}, ClickedAt: clicked})
+	if err != nil || version != 1 {
+		t.Fatalf("save new = %d, %v", version, err)
+	}
+	version, err = store.Save(ctx, private, NavigationRecord{Stack: []string{"main", "settings"}, Version: 1, ClickedAt: clicked})
+	if err != nil || version != 2 {
+		t.Fatalf("save next = %d, %v", version, err)
+	}
+
+	record, err := store.Load(ctx, private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(record.Stack, []string{"main", "settings"}) || record.Version != 2 || !record.ClickedAt.Equal(clicked) {
+		t.Errorf("load = %+v", record)
+	}
+
+	// Запись по устаревшей версии и по версии несуществующей записи - конфликт
+	if _, err := store.Save(ctx, private, NavigationRecord{Stack: []string{"main"}, Version: 1}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("save stale version = %v, want ErrVersionConflict", err)
+	}
+	if _, err := store.Save(ctx, private, NavigationRecord{Stack: []string{"main"}}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("save version 0 over existing = %v, want ErrVersionConflict", err)
+	}
+	if _, err := store.Save(ctx, message, NavigationRecord{Stack: []string{"main"}, Version: 5}); !errors.Is(err, ErrVersionConflict) {
+		t.Errorf("save missing record with version 5 = %v, want ErrVersionConflict", err)
+	}
+	if _, err := store.Load(ctx, message); !errors.Is(err, ErrNavigationNotFound) {
+		t.Errorf("conflicting save created a record: %v", err)
+	}
+
+	// Области одного пользователя независимы
+	if _, err := store.Save(ctx, message, NavigationRecord{Stack: []string{"main", "help"}, ClickedAt: clicked}); err != nil {
+		t.Fatal(err)
+	}
+	if record, err := store.Load(ctx, message); err != nil || record.Version != 1 || len(record.Stack) != 2 {
+		t.Errorf("load message scope = %+v, %v", record, err)
+	}
+	if _, err := store.Save(ctx, UserScope(2), NavigationRecord{Stack: []string{"main"}, ClickedAt: clicked}); err != nil {
+		t.Fatal(err)
+	}
+
+	if batchStore, ok := store.(BatchNavigationStore); ok {
+		versions, err := batchStore.SaveBatch(ctx, map[NavigationScope]NavigationRecord{
+			UserScope(3): {Stack: []string{"main"}, ClickedAt: clicked},             // Новая
+			private:      {Stack: []string{"main"}, Version: 1, ClickedAt: clicked}, // Устаревшая
+			UserScope(4): {Stack: []string{"main"}, Version: 7, ClickedAt: clicked}, // Удаленная
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(versions, map[NavigationScope]int64{UserScope(3): 1}) {
+			t.Errorf("save batch = %v, want only the new record", versions)
+		}
+		if _, err := store.Load(ctx, UserScope(4)); !errors.Is(err, ErrNavigationNotFound) {
+			t.Errorf("batch recreated a deleted record: %v", err)
+		}
+	}
+
+	if stats, err := store.Stats(ctx); err != nil || stats.Records < 3 || stats.MaxDepth != 2 {
+		t.Errorf("stats = %+v, %v", stats, err)
+	}
+
+	// Свежие записи не удаляются очисткой
+	if removed, err := store.Cleanup(ctx, DefaultRetentionPolicy()); err != nil || removed != 0 {
+		t.Errorf("cleanup = %d, %v", removed, err)
+	}
+
+	states, err := store.ExportUser(ctx, 1)
+	if err != nil || len(states) != 2 {
+		t.Fatalf("export = %+v, %v", states, err)
+	}
+
+	if err := store.Delete(ctx, message); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Load(ctx, message); !errors.Is(err, ErrNavigationNotFound) {
+		t.Errorf("load deleted = %v", err)
+	}
+
+	if erased, err := store.EraseUser(ctx, 1); err != nil || erased != 1 {
+		t.Errorf("erase = %d, %v", erased, err)
+	}
+	if states, err := store.ExportUser(ctx, 1); err != nil || len(states) != 0 {
+		t.Errorf("export after erase = %+v, %v", states, err)
+	}
+	if _, err := store.Load(ctx, UserScope(2)); err != nil {
+		t.Errorf("erase removed another user: %v", err)
+	}
+}
+
+// Боты в одной базе не видят и не удаляют стеки друг друга
+func TestNavigationStoreBots(t *testing.T) {
+	stores := map[string]func(t *testing.T) (NavigationStore, NavigationStore){
+		"bolt": func(t *testing.T) (NavigationStore, NavigationStore) {
+			store, err := NewBoltNavigationStore(filepath.Join(t.TempDir(), "navigation.db"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { store.Close() })
+			return store, store.WithBot("shop")
+		},
+		"postgres": func(t *testing.T) (NavigationStore, NavigationStore) {
+			store, err := NewPostgresNavigationStore(context.Background(), openTestDB(t, "postgres"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store, store.WithBot("shop")
+		},
+		"sqlite": func(t *testing.T) (NavigationStore, NavigationStore) {
+			store, err := NewSQLiteNavigationStore(context.Background(), openTestDB(t, "sqlite"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			return store, store.WithBot("shop")
+		},
+	}
+
+	for name, newStores := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			base, shop := newStores(t)
+
+			for _, store := range []NavigationStore{base, shop} {
+				if _, err := store.Save(ctx, UserScope(1), NavigationRecord{Stack: []string{"main"}}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := shop.Save(ctx, UserScope(2), NavigationRecord{Stack: []string{"main"}}); err != nil {
+				t.Fatal(err)
+			}
+
+			// Отрицательный срок - истекло все, но только у бота shop
+			if removed, err := shop.Cleanup(ctx, RetentionPolicy{Default: -time.Hour}); err != nil || removed != 2 {
+				t.Errorf("cleanup shop = %d, %v, want 2", removed, err)
+			}
+			if _, err := base.Load(ctx, UserScope(1)); err != nil {
+				t.Errorf("cleanup of another bot removed the stack: %v", err)
+			}
+			if stats, err := base.Stats(ctx); err != nil || stats.Records != 1 {
+				t.Errorf("stats = %+v, %v, want 1 record", stats, err)
+			}
+
+			if _, err := shop.Save(ctx, UserScope(1), NavigationRecord{Stack: []string{"main"}}); err != nil {
+				t.Fatal(err)
+			}
+			if erased, err := base.EraseUser(ctx, 1); err != nil || erased != 1 {
+				t.Errorf("erase = %d, %v, want 1", erased, err)
+			}
+			if states, err := shop.ExportUser(ctx, 1); err != nil || len(states) != 1 {
+				t.Errorf("erase of another bot removed the stack: %+v, %v", states, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// PostgresNavigationStore - хранилище стеков в PostgreSQL (JSONB)
+// Все запросы ограничены ботом (WithBot), поэтому боты могут делить одну базу
+type PostgresNavigationStore struct {
+	db  *sql.DB
+	bot string // Бот-владелец записей, пустой - бот по умолчанию
+}
+
+// NewPostgresNavigationStore создает хранилище и применяет миграции схемы
+// Возвращает ошибку, если схему создать не удалось - работать без таблицы нельзя
+func NewPostgresNavigationStore(ctx context.Context, db *sql.DB) (*PostgresNavigationStore, error) {
+	migrator, err := NewPostgresMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		log.Printf("❌ Ошибка миграции таблицы навигации: %v", err)
+		return nil, err
+	}
+
+	log.Println("✅ Таблица навигации готова")
+	return &PostgresNavigationStore{db: db}, nil
+}
+
+// WithBot возвращает хранилище стеков бота bot в той же базе
+// Чтение, запись, очистка по сроку хранения и удаление пользователя не затрагивают другие боты.
+func (pns *PostgresNavigationStore) WithBot(bot string) *PostgresNavigationStore {
+	return &PostgresNavigationStore{db: pns.db, bot: bot}
+}
+
+// Load загружает стек из БД
+func (pns *PostgresNavigationStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	var stackJSON []byte
+	var record NavigationRecord
+	query := `
+    SELECT menu_stack, version, clicked_at FROM user_navigation
+    WHERE bot = $1 AND user_id = $2 AND chat_id = $3 AND thread_id = $4 AND message_id = $5
+    `
+
+	err := pns.db.QueryRowContext(ctx, query, pns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID).
+		Scan(&stackJSON, &record.Version, &record.ClickedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NavigationRecord{}, storeNotFound("load", scope) // Новый пользователь
+		}
+		return NavigationRecord{}, storeUnavailable("load", scope, err)
+	}
+
+	if err := json.Unmarshal(stackJSON, &record.Stack); err != nil {
+		return NavigationRecord{}, storeCorrupt("load", scope, err)
+	}
+	if record.Stack == nil {
+		record.Stack = []string{}
+	}
+
+	return record, nil
+}
+
+// Условная запись пачки: обновление и вставка разделены.
+// Существующая строка обновляется, только если ее версия не изменилась, новая вставляется
+// только с версией 1 (в хранилище записи не было). Строки, не прошедшие проверку,
+// не попадают в результат - это конфликты, в том числе запись удаленной строки по старой версии.
+// $1 - бот, строки пачки начинаются с $2.
+const (
+	postgresSavePrefix = `
+    WITH input (user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at) AS (
+        VALUES `
+	postgresSaveSuffix = `
+    ),
+    updated AS (
+        UPDATE user_navigation AS n SET
+            menu_stack = i.menu_stack,
+            version = i.version,
+            clicked_at = i.clicked_at,
+            updated_at = CURRENT_TIMESTAMP
+        FROM input AS i
+        WHERE n.bot = $1 AND n.user_id = i.user_id AND n.chat_id = i.chat_id AND n.thread_id = i.thread_id
+          AND n.message_id = i.message_id AND n.version = i.version - 1
+        RETURNING n.user_id, n.chat_id, n.thread_id, n.message_id, n.version
+    ),
+    inserted AS (
+        INSERT INTO user_navigation (bot, user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at)
+        SELECT $1::TEXT, user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at, CURRENT_TIMESTAMP
+        FROM input WHERE version = 1
+        ON CONFLICT (bot, user_id, chat_id, thread_id, message_id) DO NOTHING
+        RETURNING user_id, chat_id, thread_id, message_id, version
+    )
+    SELECT * FROM updated
+    UNION ALL
+    SELECT * FROM inserted
+    `
+)
+
+// Save сохраняет стек в БД, если версия в БД не изменилась
+func (pns *PostgresNavigationStore) Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error) {
+	versions, err := pns.SaveBatch(ctx, map[NavigationScope]NavigationRecord{scope: record})
+	if err != nil {
+		return 0, err
+	}
+
+	version, saved := versions[scope]
+	if !saved {
+		return 0, storeConflict("save", scope)
+	}
+	return version, nil
+}
+
+// SaveBatch сохраняет несколько стеков одним запросом с проверкой версий
+// Возвращает новые версии записанных стеков, областей с конфликтом в результате нет
+func (pns *PostgresNavigationStore) SaveBatch(ctx context.Context, records map[NavigationScope]NavigationRecord) (map[NavigationScope]int64, error) {
+	if len(records) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, 0, len(records))
+	args := make([]interface{}, 1, len(records)*7+1)
+	args[0] = pns.bot
+
+	for scope, record := range records {
+		stackJSON, err := json.Marshal(record.Stack)
+		if err != nil {
+			return nil, storeCorrupt("save", scope, err)
+		}
+		n := len(args)
+		values = append(values, fmt.Sprintf("($%d::BIGINT, $%d::BIGINT, $%d::BIGINT, $%d::BIGINT, $%d::JSONB, $%d::BIGINT, $%d::TIMESTAMP)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7))
+		args = append(args, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID, stackJSON, record.Version+1, record.ClickedAt.UTC())
+	}
+
+	query := postgresSavePrefix + strings.Join(values, ", ") + postgresSaveSuffix
+
+	rows, err := pns.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, postgresSaveError(err)
+	}
+	defer rows.Close()
+
+	versions := make(map[NavigationScope]int64, len(records))
+	for rows.Next() {
+		var scope NavigationScope
+		var version int64
+		if err := rows.Scan(&scope.UserID, &scope.ChatID, &scope.ThreadID, &scope.MessageID, &version); err != nil {
+			return nil, postgresSaveError(err)
+		}
+		versions[scope] = version
+	}
+	return versions, postgresSaveError(rows.Err())
+}
+
+// postgresSaveError классифицирует ошибку записи по коду PostgreSQL
+// Данные, отвергнутые схемой (классы 22 и 23), повтором не запишутся - это испорченный стек.
+// Сбой сериализации и взаимоблокировка - конкурентная запись, она разрешается как конфликт версий.
+// Остальное (сеть, таймауты, перегрузка) - недоступность хранилища, запись повторится позже.
+func postgresSaveError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Class() == "22" || pqErr.Code.Class() == "23":
+			return storeCorrupt("save", NavigationScope{}, err)
+		case pqErr.Code == "40001" || pqErr.Code == "40P01":
+			return &StoreError{Op: "save", Kind: ErrVersionConflict, Err: err}
+		}
+	}
+	return storeUnavailable("save", NavigationScope{}, err)
+}
+
+// Delete удаляет стек пользователя
+func (pns *PostgresNavigationStore) Delete(ctx context.Context, scope NavigationScope) error {
+	query := "DELETE FROM user_navigation WHERE bot = $1 AND user_id = $2 AND chat_id = $3 AND thread_id = $4 AND message_id = $5"
+	_, err := pns.db.ExecContext(ctx, query, pns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID)
+	return storeUnavailable("delete", scope, err)
+}
+
+// Cleanup удаляет данные навигации бота с истекшим сроком хранения
+func (pns *PostgresNavigationStore) Cleanup(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	query := `
+    DELETE FROM user_navigation
+    WHERE bot = $1 AND (
+           (message_id = 0 AND chat_id = user_id AND updated_at < $2)
+        OR (message_id = 0 AND chat_id <> user_id AND updated_at < $3)
+        OR (message_id <> 0 AND updated_at < $4))
+    `
+	private, group, message := policy.Cutoffs(time.Now())
+
+	result, err := pns.db.ExecContext(ctx, query, pns.bot, private, group, message)
+	if err != nil {
+		return 0, storeUnavailable("cleanup", NavigationScope{}, err)
+	}
+
+	affected, err := result.RowsAffected()
+	return affected, storeUnavailable("cleanup", NavigationScope{}, err)
+}
+
+// ExportUser возвращает все стеки пользователя
+func (pns *PostgresNavigationStore) ExportUser(ctx context.Context, userID int64) ([]NavigationState, error) {
+	query := `
+    SELECT chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at
+    FROM user_navigation
+    WHERE bot = $1 AND user_id = $2
+    ORDER BY chat_id, thread_id, message_id
+    `
+
+	rows, err := pns.db.QueryContext(ctx, query, pns.bot, userID)
+	if err != nil {
+		return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+	}
+	defer rows.Close()
+
+	var states []NavigationState
+	for rows.Next() {
+		state := NavigationState{UserID: userID}
+		var stackJSON []byte
+		var updatedAt sql.NullTime
+
+		err := rows.Scan(&state.ChatID, &state.ThreadID, &state.MessageID, &stackJSON,
+			&state.Version, &state.ClickedAt, &updatedAt)
+		if err != nil {
+			return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+		}
+		if err := json.Unmarshal(stackJSON, &state.MenuStack); err != nil {
+			return nil, storeCorrupt("export", state.scope(), err)
+		}
+		state.UpdatedAt = updatedAt.Time
+		states = append(states, state)
+	}
+	return states, storeUnavailable("export", NavigationScope{UserID: userID}, rows.Err())
+}
+
+// EraseUser удаляет все стеки пользователя
+func (pns *PostgresNavigationStore) EraseUser(ctx context.Context, userID int64) (int64, error) {
+	result, err := pns.db.ExecContext(ctx, "DELETE FROM user_navigation WHERE bot = $1 AND user_id = $2", pns.bot, userID)
+	if err != nil {
+		return 0, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+	}
+
+	affected, err := result.RowsAffected()
+	return affected, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+}
+
+// Stats возвращает статистику из БД
+func (pns *PostgresNavigationStore) Stats(ctx context.Context) (StoreStats, error) {
+	var stats StoreStats
+
+	query := `
+    SELECT
+        COUNT(*) as total_records,
+        COALESCE(AVG(jsonb_array_length(menu_stack)), 0) as avg_depth,
+        COALESCE(MAX(jsonb_array_length(menu_stack)), 0) as max_depth
+    FROM user_navigation
+    WHERE bot = $1
+    `
+
+	err := pns.db.QueryRowContext(ctx, query, pns.bot).Scan(&stats.Records, &stats.AvgDepth, &stats.MaxDepth)
+	return stats, storeUnavailable("stats", NavigationScope{}, err)
+}
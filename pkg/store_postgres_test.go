@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestPostgresSaveError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{&pq.Error{Code: "23505"}, ErrCorruptStack},
+		{&pq.Error{Code: "22P02"}, ErrCorruptStack},
+		{&pq.Error{Code: "40001"}, ErrVersionConflict},
+		{&pq.Error{Code: "40P01"}, ErrVersionConflict},
+		{&pq.Error{Code: "57P01"}, ErrStoreUnavailable},
+		{errors.New("connection reset"), ErrStoreUnavailable},
+	}
+
+	for _, tt := range tests {
+		if err := postgresSaveError(tt.err); !errors.Is(err, tt.want) {
+			t.Errorf("postgresSaveError(%v) = %v, want %v", tt.err, err, tt.want)
+		}
+	}
+	if err := postgresSaveError(nil); err != nil {
+		t.Errorf("postgresSaveError(nil) = %v", err)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+// SQLiteNavigationStore - хранилище стеков в SQLite
+// Драйвер (например, modernc.org/sqlite или mattn/go-sqlite3) подключает вызывающий код.
+// Все запросы ограничены ботом (WithBot), поэтому боты могут делить один файл базы.
+type SQLiteNavigationStore struct {
+	db  *sql.DB
+	bot string // Бот-владелец записей, пустой - бот по умолчанию
+}
+
+// NewSQLiteNavigationStore создает хранилище и применяет миграции схемы
+func NewSQLiteNavigationStore(ctx context.Context, db *sql.DB) (*SQLiteNavigationStore, error) {
+	migrator, err := NewSQLiteMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := migrator.Up(ctx); err != nil {
+		return nil, err
+	}
+	return &SQLiteNavigationStore{db: db}, nil
+}
+
+// WithBot возвращает хранилище стеков бота bot в той же базе
+// Чтение, запись, очистка по сроку хранения и удаление пользователя не затрагивают другие боты.
+func (sns *SQLiteNavigationStore) WithBot(bot string) *SQLiteNavigationStore {
+	return &SQLiteNavigationStore{db: sns.db, bot: bot}
+}
+
+// Load загружает стек из БД
+func (sns *SQLiteNavigationStore) Load(ctx context.Context, scope NavigationScope) (NavigationRecord, error) {
+	var stackJSON string
+	var record NavigationRecord
+	query := `
+    SELECT menu_stack, version, clicked_at FROM user_navigation
+    WHERE bot = ? AND user_id = ? AND chat_id = ? AND thread_id = ? AND message_id = ?
+    `
+
+	err := sns.db.QueryRowContext(ctx, query, sns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID).
+		Scan(&stackJSON, &record.Version, &record.ClickedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return NavigationRecord{}, storeNotFound("load", scope) // Новый пользователь
+		}
+		return NavigationRecord{}, storeUnavailable("load", scope, err)
+	}
+
+	if err := json.Unmarshal([]byte(stackJSON), &record.Stack); err != nil {
+		return NavigationRecord{}, storeCorrupt("load", scope, err)
+	}
+	if record.Stack == nil {
+		record.Stack = []string{}
+	}
+	return record, nil
+}
+
+// Save сохраняет стек в БД, если версия в БД не изменилась
+// Версия 0 - вставка (или перезапись строки, созданной до появления версий), иначе - обновление
+// строки с той же версией. Не прошедшая проверку запись не меняет строк - RowsAffected будет 0.
+func (sns *SQLiteNavigationStore) Save(ctx context.Context, scope NavigationScope, record NavigationRecord) (int64, error) {
+	stackJSON, err := json.Marshal(record.Stack)
+	if err != nil {
+		return 0, storeCorrupt("save", scope, err)
+	}
+
+	version := record.Version + 1
+	clickedAt, updatedAt := record.ClickedAt.UTC(), time.Now().UTC()
+
+	var result sql.Result
+	if record.Version == 0 {
+		query := `
+    INSERT INTO user_navigation (bot, user_id, chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at)
+    VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+    ON CONFLICT (bot, user_id, chat_id, thread_id, message_id)
+    DO UPDATE SET
+        menu_stack = excluded.menu_stack,
+        version = excluded.version,
+        clicked_at = excluded.clicked_at,
+        updated_at = excluded.updated_at
+    WHERE user_navigation.version = 0
+    `
+		result, err = sns.db.ExecContext(ctx, query, sns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID,
+			string(stackJSON), version, clickedAt, updatedAt)
+	} else {
+		query := `
+    UPDATE user_navigation SET menu_stack = ?, version = ?, clicked_at = ?, updated_at = ?
+    WHERE bot = ? AND user_id = ? AND chat_id = ? AND thread_id = ? AND message_id = ? AND version = ?
+    `
+		result, err = sns.db.ExecContext(ctx, query, string(stackJSON), version, clickedAt, updatedAt,
+			sns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID, record.Version)
+	}
+	if err != nil {
+		return 0, storeUnavailable("save", scope, err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, storeUnavailable("save", scope, err)
+	}
+	if affected == 0 {
+		return 0, storeConflict("save", scope)
+	}
+	return version, nil
+}
+
+// Delete удаляет стек пользователя
+func (sns *SQLiteNavigationStore) Delete(ctx context.Context, scope NavigationScope) error {
+	query := "DELETE FROM user_navigation WHERE bot = ? AND user_id = ? AND chat_id = ? AND thread_id = ? AND message_id = ?"
+	_, err := sns.db.ExecContext(ctx, query, sns.bot, scope.UserID, scope.ChatID, scope.ThreadID, scope.MessageID)
+	return storeUnavailable("delete", scope, err)
+}
+
+// Cleanup удаляет данные навигации бота с истекшим сроком хранения
+func (sns *SQLiteNavigationStore) Cleanup(ctx context.Context, policy RetentionPolicy) (int64, error) {
+	query := `
+    DELETE FROM user_navigation
+    WHERE bot = ? AND (
+           (message_id = 0 AND chat_id = user_id AND updated_at < ?)
+        OR (message_id = 0 AND chat_id <> user_id AND updated_at < ?)
+        OR (message_id <> 0 AND updated_at < ?))
+    `
+	private, group, message := policy.Cutoffs(time.Now().UTC())
+
+	result, err := sns.db.ExecContext(ctx, query, sns.bot, private, group, message)
+	if err != nil {
+		return 0, storeUnavailable("cleanup", NavigationScope{}, err)
+	}
+
+	affected, err := result.RowsAffected()
+	return affected, storeUnavailable("cleanup", NavigationScope{}, err)
+}
+
+// ExportUser возвращает все стеки пользователя
+func (sns *SQLiteNavigationStore) ExportUser(ctx context.Context, userID int64) ([]NavigationState, error) {
+	query := `
+    SELECT chat_id, thread_id, message_id, menu_stack, version, clicked_at, updated_at
+    FROM user_navigation
+    WHERE bot = ? AND user_id = ?
+    ORDER BY chat_id, thread_id, message_id
+    `
+
+	rows, err := sns.db.QueryContext(ctx, query, sns.bot, userID)
+	if err != nil {
+		return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+	}
+	defer rows.Close()
+
+	var states []NavigationState
+	for rows.Next() {
+		state := NavigationState{UserID: userID}
+		var stackJSON string
+
+		err := rows.Scan(&state.ChatID, &state.ThreadID, &state.MessageID, &stackJSON,
+			&state.Version, &state.ClickedAt, &state.UpdatedAt)
+		if err != nil {
+			return nil, storeUnavailable("export", NavigationScope{UserID: userID}, err)
+		}
+		if err := json.Unmarshal([]byte(stackJSON), &state.MenuStack); err != nil {
+			return nil, storeCorrupt("export", state.scope(), err)
+		}
+		states = append(states, state)
+	}
+	return states, storeUnavailable("export", NavigationScope{UserID: userID}, rows.Err())
+}
+
+// EraseUser удаляет все стеки пользователя
+func (sns *SQLiteNavigationStore) EraseUser(ctx context.Context, userID int64) (int64, error) {
+	result, err := sns.db.ExecContext(ctx, "DELETE FROM user_navigation WHERE bot = ? AND user_id = ?", sns.bot, userID)
+	if err != nil {
+		return 0, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+	}
+
+	affected, err := result.RowsAffected()
+	return affected, storeUnavailable("erase", NavigationScope{UserID: userID}, err)
+}
+
+// Stats возвращает статистику из БД (нужно расширение JSON1, есть во всех сборках)
+func (sns *SQLiteNavigationStore) Stats(ctx context.Context) (StoreStats, error) {
+	var stats StoreStats
+
+	query := `
+    SELECT
+        COUNT(*),
+        COALESCE(AVG(json_array_length(menu_stack)), 0),
+        COALESCE(MAX(json_array_length(menu_stack)), 0)
+    FROM user_navigation
+    WHERE bot = ?
+    `
+
+	err := sns.db.QueryRowContext(ctx, query, sns.bot).Scan(&stats.Records, &stats.AvgDepth, &stats.MaxDepth)
+	return stats, storeUnavailable("stats", NavigationScope{}, err)
+}
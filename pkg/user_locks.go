@@ -0,0 +1,55 @@
+package main
+
+import "sync"
+
+// userLocks - блокировки по пользователям
+// Медленный запрос к БД держит только блокировку своего пользователя, остальные не ждут.
+// Блокировка удаляется, когда ее никто не держит и не ждет, поэтому память не растет.
+type userLocks struct {
+	locks map[NavigationScope]*userLock
+	mutex sync.Mutex
+}
+
+type userLock struct {
+	mutex sync.Mutex
+	refs  int // Сколько горутин держат или ждут блокировку
+}
+
+func newUserLocks() *userLocks {
+	return &userLocks{
+		locks: make(map[NavigationScope]*userLock),
+	}
+}
+
+// Lock захватывает блокировку пользователя и возвращает функцию для ее освобождения
+func (ul *userLocks) Lock(scope NavigationScope) (unlock func()) {
+	ul.mutex.Lock()
+	lock, exists := ul.locks[scope]
+	if !exists {
+		lock = &userLock{}
+		ul.locks[scope] = lock
+	}
+	lock.refs++
+	ul.mutex.Unlock()
+
+	lock.mutex.Lock()
+
+	return func() {
+		lock.mutex.Unlock()
+
+		ul.mutex.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(ul.locks, scope)
+		}
+		ul.mutex.Unlock()
+	}
+}
+
+// Len возвращает количество пользователей, для которых сейчас есть блокировка
+func (ul *userLocks) Len() int {
+	ul.mutex.Lock()
+	defer ul.mutex.Unlock()
+
+	return len(ul.locks)
+}
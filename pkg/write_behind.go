@@ -0,0 +1,566 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BatchNavigationStore - хранилище, умеющее сохранять несколько стеков одним запросом
+// Возвращает новые версии записанных стеков, пользователи с конфликтом версий в результат не попадают
+type BatchNavigationStore interface {
+	SaveBatch(ctx context.Context, records map[NavigationScope]NavigationRecord) (map[NavigationScope]int64, error)
+}
+
+// maxConflictRetries - сколько раз повторять запись после конфликта версий
+const maxConflictRetries = 3
+
+// ErrWriteQueueFull - очередь записи переполнена, стек не будет сохранен
+var ErrWriteQueueFull = errors.New("navigation write queue is full")
+
+// ErrWriteQueueClosed - менеджер остановлен, стек не будет сохранен
+var ErrWriteQueueClosed = errors.New("navigation write queue is closed")
+
+// writeBehindQueue - упорядоченная очередь отложенной записи стеков
+// Для каждого пользователя хранится только последний стек, поэтому быстрые клики
+// не могут записаться в неправильном порядке. Пишет один воркер пачками.
+type writeBehindQueue struct {
+	store   NavigationStore
+	breaker *circuitBreaker // Пока хранилище недоступно, стеки копятся в очереди
+
+	pending map[NavigationScope]NavigationRecord // Область -> последний несохраненный стек
+	erasing map[int64]int                        // Пользователи, чьи данные удаляются - их стеки не пишутся
+	slots   chan struct{}                        // Свободные места в очереди (backpressure)
+	wake    chan struct{}                        // Сигнал воркеру, что появилась работа
+	mutex   sync.Mutex
+	closed  atomic.Bool
+
+	flushing sync.Mutex // Держится на время записи пачки, удаление пользователя ждет ее окончания
+
+	batchSize      int
+	flushInterval  time.Duration
+	enqueueTimeout time.Duration
+	saveTimeout    time.Duration // Таймаут записи одной пачки
+	maxDepth       int           // Глубина стека после слияния с временным стеком (Degraded)
+
+	// Повтор записи при недоступном хранилище, защищено mutex
+	retryDelay    time.Duration
+	maxRetryDelay time.Duration
+	retryAt       time.Time
+
+	saved     atomic.Int64
+	coalesced atomic.Int64
+	dropped   atomic.Int64
+	failed    atomic.Int64
+	retried   atomic.Int64
+
+	conflicts  atomic.Int64 // Запись обнаружила чужое изменение
+	merged     atomic.Int64 // После конфликта записан наш, более поздний клик
+	superseded atomic.Int64 // После конфликта в хранилище остался более поздний чужой клик
+
+	onError func(scope NavigationScope, err error)
+
+	// Вызывается воркером после записи: новые версии записанных стеков
+	// и пользователи, чей стек в хранилище оказался новее нашего
+	onSaved func(versions map[NavigationScope]int64, superseded []NavigationScope)
+}
+
+func newWriteBehindQueue(store NavigationStore, breaker *circuitBreaker, capacity int) *writeBehindQueue {
+	return &writeBehindQueue{
+		store:          store,
+		breaker:        breaker,
+		pending:        make(map[NavigationScope]NavigationRecord),
+		erasing:        make(map[int64]int),
+		slots:          make(chan struct{}, capacity),
+		wake:           make(chan struct{}, 1),
+		batchSize:      100,
+		flushInterval:  200 * time.Millisecond,
+		enqueueTimeout: 2 * time.Second,
+		saveTimeout:    5 * time.Second,
+		maxDepth:       20,
+		maxRetryDelay:  30 * time.Second,
+		onError: func(scope NavigationScope, err error) {
+			log.Printf("❌ Ошибка сохранения навигации для %v: %v", scope, err)
+		},
+	}
+}
+
+// Enqueue ставит стек пользователя в очередь на запись
+// Если для пользователя уже есть несохраненный стек, он заменяется (coalescing).
+// Если очередь заполнена, вызов ждет enqueueTimeout, а затем запись отбрасывается.
+func (wq *writeBehindQueue) Enqueue(scope NavigationScope, record NavigationRecord) error {
+	if wq.closed.Load() {
+		return ErrWriteQueueClosed
+	}
+
+	if wq.replace(scope, record) {
+		return nil
+	}
+
+	// Новый пользователь в очереди - занимаем место
+	timer := time.NewTimer(wq.enqueueTimeout)
+	defer timer.Stop()
+
+	select {
+	case wq.slots <- struct{}{}:
+	case <-timer.C:
+		wq.dropped.Add(1)
+		log.Printf("⚠️  Очередь записи навигации переполнена, стек %v не сохранен", scope)
+		return ErrWriteQueueFull
+	}
+
+	wq.mutex.Lock()
+	if _, exists := wq.pending[scope]; exists {
+		// Пока ждали место, стек уже поставили в очередь - место не нужно
+		wq.pending[scope] = record
+		wq.mutex.Unlock()
+		<-wq.slots
+		wq.coalesced.Add(1)
+		return nil
+	}
+	wq.pending[scope] = record
+	wq.mutex.Unlock()
+
+	wq.wakeUp()
+	return nil
+}
+
+// wakeUp будит воркер, не блокируясь
+func (wq *writeBehindQueue) wakeUp() {
+	select {
+	case wq.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Peek возвращает еще не записанный стек пользователя
+// Он новее, чем стек в хранилище, поэтому читается раньше хранилища
+func (wq *writeBehindQueue) Peek(scope NavigationScope) (NavigationRecord, bool) {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	record, exists := wq.pending[scope]
+	return record, exists
+}
+
+// PeekUser возвращает все еще не записанные стеки пользователя
+func (wq *writeBehindQueue) PeekUser(userID int64) map[NavigationScope]NavigationRecord {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	records := make(map[NavigationScope]NavigationRecord)
+	for scope, record := range wq.pending {
+		if scope.UserID == userID {
+			records[scope] = record
+		}
+	}
+	return records
+}
+
+// DropUser убирает из очереди все стеки пользователя и возвращает их количество
+// Нужен при удалении данных пользователя, чтобы очередь не записала их обратно
+func (wq *writeBehindQueue) DropUser(userID int64) int {
+	wq.mutex.Lock()
+	dropped := wq.dropUserLocked(userID)
+	wq.mutex.Unlock()
+
+	for i := 0; i < dropped; i++ {
+		<-wq.slots
+	}
+	return dropped
+}
+
+func (wq *writeBehindQueue) dropUserLocked(userID int64) int {
+	var dropped int
+	for scope := range wq.pending {
+		if scope.UserID == userID {
+			delete(wq.pending, scope)
+			dropped++
+		}
+	}
+	return dropped
+}
+
+// BeginErase перестает писать стеки пользователя и ждет окончания пачки, которая уже пишется
+// После возврата очередь не запишет пользователя, пока не вызван EndErase.
+// Возвращает, сколько стеков убрано из очереди.
+func (wq *writeBehindQueue) BeginErase(userID int64) int {
+	wq.mutex.Lock()
+	wq.erasing[userID]++
+	wq.mutex.Unlock()
+
+	dropped := wq.DropUser(userID)
+
+	// Пачка, забранная до отметки, могла еще не дойти до хранилища
+	wq.flushing.Lock()
+	wq.flushing.Unlock()
+	return dropped
+}
+
+// EndErase снимает отметку удаления, новые клики пользователя снова записываются
+func (wq *writeBehindQueue) EndErase(userID int64) {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	if wq.erasing[userID]--; wq.erasing[userID] <= 0 {
+		delete(wq.erasing, userID)
+	}
+}
+
+// isErasing сообщает, что данные пользователя удаляются
+func (wq *writeBehindQueue) isErasing(userID int64) bool {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	return wq.erasing[userID] > 0
+}
+
+// resync сбрасывает паузу между повторами и будит воркер - хранилище снова доступно
+func (wq *writeBehindQueue) resync() {
+	wq.mutex.Lock()
+	wq.retryDelay = 0
+	wq.retryAt = time.Time{}
+	dirty := len(wq.pending)
+	wq.mutex.Unlock()
+
+	if dirty > 0 {
+		log.Printf("🔄 Досохраняем %d стеков навигации после восстановления хранилища", dirty)
+	}
+	wq.wakeUp()
+}
+
+// replace заменяет уже ожидающий стек пользователя
+func (wq *writeBehindQueue) replace(scope NavigationScope, record NavigationRecord) bool {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	if _, exists := wq.pending[scope]; !exists {
+		return false
+	}
+	wq.pending[scope] = record
+	wq.coalesced.Add(1)
+	return true
+}
+
+// run - воркер очереди, пишет пачками по сигналу или по таймеру до закрытия stop
+func (wq *writeBehindQueue) run(ctx context.Context) {
+	ticker := time.NewTicker(wq.flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-wq.wake:
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+		if wq.backingOff() {
+			continue
+		}
+		for ctx.Err() == nil && wq.flushWithTimeout(ctx) > 0 {
+		}
+	}
+}
+
+// backingOff сообщает, что после неудачной записи еще не прошла пауза
+func (wq *writeBehindQueue) backingOff() bool {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	return time.Now().Before(wq.retryAt)
+}
+
+// flushWithTimeout записывает одну пачку с таймаутом saveTimeout, отмена ctx прерывает запись
+func (wq *writeBehindQueue) flushWithTimeout(ctx context.Context) int {
+	ctx, cancel := context.WithTimeout(ctx, wq.saveTimeout)
+	defer cancel()
+
+	return wq.flushBatch(ctx)
+}
+
+// close перестает принимать новые стеки
+func (wq *writeBehindQueue) close() {
+	wq.closed.Store(true)
+}
+
+// drain дописывает оставшиеся стеки до дедлайна ctx
+// Возвращает, сколько стеков записано и сколько осталось несохраненными
+func (wq *writeBehindQueue) drain(ctx context.Context) (flushed, abandoned int) {
+	savedBefore, failedBefore := wq.saved.Load(), wq.failed.Load()
+
+	for ctx.Err() == nil && wq.flushBatch(ctx) > 0 {
+	}
+
+	wq.mutex.Lock()
+	pending := len(wq.pending)
+	wq.mutex.Unlock()
+
+	// Стеки, запись которых оборвалась по дедлайну, тоже потеряны
+	flushed = int(wq.saved.Load() - savedBefore)
+	abandoned = pending + int(wq.failed.Load()-failedBefore)
+	return flushed, abandoned
+}
+
+// flushBatch забирает из очереди до batchSize стеков и сохраняет их
+// Возвращает, сколько стеков покинуло очередь; стеки для повтора остаются в ней
+func (wq *writeBehindQueue) flushBatch(ctx context.Context) int {
+	// Пустая очередь не должна занимать пробный запрос полуоткрытого предохранителя
+	wq.mutex.Lock()
+	empty := len(wq.pending) == 0
+	wq.mutex.Unlock()
+	if empty || !wq.breaker.Allow() {
+		return 0
+	}
+
+	wq.flushing.Lock()
+	defer wq.flushing.Unlock()
+
+	wq.mutex.Lock()
+	batch := make(map[NavigationScope]NavigationRecord, wq.batchSize)
+	var erased int
+	for scope, record := range wq.pending {
+		if len(batch) >= wq.batchSize {
+			break
+		}
+		delete(wq.pending, scope)
+		if wq.erasing[scope.UserID] > 0 {
+			erased++ // Клик во время удаления пользователя
+			continue
+		}
+		batch[scope] = record
+	}
+	wq.mutex.Unlock()
+
+	for i := 0; i < erased; i++ {
+		<-wq.slots
+	}
+	if len(batch) == 0 {
+		return erased
+	}
+
+	retry := wq.save(ctx, batch)
+	released := len(batch) - wq.requeue(retry)
+
+	// Освобождаем места только после записи - иначе очередь не ограничивает нагрузку на БД
+	for i := 0; i < released; i++ {
+		<-wq.slots
+	}
+	return erased + len(batch) - len(retry)
+}
+
+// requeue возвращает в очередь стеки, которые не удалось записать из-за недоступности хранилища
+// Если пользователь за это время кликнул снова, остается более новый стек.
+// Возвращает, сколько стеков вернулось в очередь и продолжает занимать места.
+func (wq *writeBehindQueue) requeue(retry map[NavigationScope]NavigationRecord) int {
+	wq.mutex.Lock()
+	defer wq.mutex.Unlock()
+
+	if len(retry) == 0 {
+		wq.retryDelay = 0
+		return 0
+	}
+
+	// Экспоненциальная пауза перед следующей попыткой
+	wq.retryDelay *= 2
+	if wq.retryDelay == 0 {
+		wq.retryDelay = wq.flushInterval
+	}
+	if wq.retryDelay > wq.maxRetryDelay {
+		wq.retryDelay = wq.maxRetryDelay
+	}
+	wq.retryAt = time.Now().Add(wq.retryDelay)
+
+	var kept int
+	for scope, record := range retry {
+		if _, exists := wq.pending[scope]; exists || wq.erasing[scope.UserID] > 0 {
+			continue
+		}
+		wq.pending[scope] = record
+		kept++
+	}
+	wq.retried.Add(int64(len(retry)))
+	return kept
+}
+
+// save сохраняет пачку одним запросом, если хранилище это умеет
+// Возвращает стеки, которые нужно повторить, когда хранилище станет доступно
+func (wq *writeBehindQueue) save(ctx context.Context, batch map[NavigationScope]NavigationRecord) map[NavigationScope]NavigationRecord {
+	result := saveResult{
+		versions: make(map[NavigationScope]int64, len(batch)),
+		retry:    make(map[NavigationScope]NavigationRecord),
+	}
+
+	if batchStore, ok := wq.store.(BatchNavigationStore); ok {
+		versions, err := batchStore.SaveBatch(ctx, batch)
+		wq.breaker.Record(err)
+
+		switch {
+		case err == nil:
+			for scope, record := range batch {
+				if version, saved := versions[scope]; saved {
+					wq.saved.Add(1)
+					result.versions[scope] = version
+					continue
+				}
+				wq.resolveConflict(ctx, scope, record, &result)
+			}
+			wq.notifySaved(result)
+			return result.retry
+		case errors.Is(err, ErrStoreUnavailable) || ctx.Err() != nil:
+			return batch
+		}
+		// Пачка не записалась - пробуем по одному, чтобы найти проблемные стеки
+	}
+
+	// Доступ к хранилищу уже разрешил flushBatch, здесь только проверяем, не открылся ли
+	// предохранитель на предыдущем стеке пачки - повторный Allow занял бы пробный запрос
+	for scope, record := range batch {
+		if wq.breaker.State() == BreakerOpen {
+			result.retry[scope] = record
+			continue
+		}
+
+		version, err := wq.store.Save(ctx, scope, record)
+		wq.breaker.Record(err)
+		switch {
+		case err == nil:
+			wq.saved.Add(1)
+			result.versions[scope] = version
+		case errors.Is(err, ErrVersionConflict):
+			wq.resolveConflict(ctx, scope, record, &result)
+		case errors.Is(err, ErrStoreUnavailable) || ctx.Err() != nil:
+			// Запись прервана (остановка или таймаут) - стек дописывает следующая пачка или drain
+			result.retry[scope] = record
+		default:
+			wq.failed.Add(1)
+			wq.onError(scope, err)
+		}
+	}
+
+	wq.notifySaved(result)
+	return result.retry
+}
+
+// saveResult - итог записи пачки
+type saveResult struct {
+	versions   map[NavigationScope]int64            // Записанные стеки и их новые версии
+	superseded []NavigationScope                    // В хранилище остался более поздний клик
+	retry      map[NavigationScope]NavigationRecord // Повторить, когда хранилище станет доступно
+}
+
+// resolveConflict сливает наш стек с изменившейся записью по времени клика
+// Если в хранилище более поздний клик (другая реплика), наш стек отбрасывается,
+// иначе записывается поверх новой версии. Так в итоге остается последний клик пользователя.
+// Стек, начатый без хранилища (Degraded), дописывается к сохраненному, чтобы не потерять историю.
+// Если записи больше нет (пользователя удалили), стек не создается заново.
+func (wq *writeBehindQueue) resolveConflict(ctx context.Context, scope NavigationScope, ours NavigationRecord, result *saveResult) {
+	wq.conflicts.Add(1)
+
+	record := ours
+	err := storeConflict("save", scope)
+	for attempt := 0; attempt < maxConflictRetries && errors.Is(err, ErrVersionConflict); attempt++ {
+		var current NavigationRecord
+		current, err = wq.store.Load(ctx, scope)
+		wq.breaker.Record(err)
+
+		// Записи нет или ее вот-вот удалят вместе с пользователем - не создаем заново
+		if errors.Is(err, ErrNavigationNotFound) || wq.isErasing(scope.UserID) {
+			wq.superseded.Add(1)
+			result.superseded = append(result.superseded, scope)
+			return
+		} else if err != nil {
+			break
+		} else if current.ClickedAt.After(ours.ClickedAt) {
+			wq.superseded.Add(1)
+			result.superseded = append(result.superseded, scope)
+			return
+		} else {
+			record.Version = current.Version
+			if ours.Degraded {
+				record.Stack = mergeStacks(current.Stack, ours.Stack, wq.maxDepth)
+				record.Degraded = false
+			}
+		}
+
+		var version int64
+		version, err = wq.store.Save(ctx, scope, record)
+		wq.breaker.Record(err)
+		if err == nil {
+			wq.merged.Add(1)
+			wq.saved.Add(1)
+			result.versions[scope] = version
+			if ours.Degraded {
+				// В кэше остался временный стек без сохраненной истории - перечитываем
+				result.superseded = append(result.superseded, scope)
+			}
+			return
+		}
+	}
+
+	if errors.Is(err, ErrStoreUnavailable) {
+		result.retry[scope] = ours
+		return
+	}
+	wq.failed.Add(1)
+	wq.onError(scope, err)
+}
+
+// mergeStacks дописывает к сохраненному стеку клики временного стека
+// Общий участок (конец stored совпадает с началом ours) не повторяется, результат - не глубже maxDepth.
+func mergeStacks(stored, ours []string, maxDepth int) []string {
+	overlap := len(ours)
+	if len(stored) < overlap {
+		overlap = len(stored)
+	}
+	for ; overlap > 0; overlap-- {
+		if sameMenus(stored[len(stored)-overlap:], ours[:overlap]) {
+			break
+		}
+	}
+
+	merged := append(append([]string(nil), stored...), ours[overlap:]...)
+	if maxDepth > 0 && len(merged) > maxDepth {
+		merged = merged[len(merged)-maxDepth:]
+	}
+	return merged
+}
+
+// sameMenus сравнивает участки стеков одинаковой длины
+func sameMenus(a, b []string) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (wq *writeBehindQueue) notifySaved(result saveResult) {
+	if wq.onSaved == nil || (len(result.versions) == 0 && len(result.superseded) == 0) {
+		return
+	}
+	wq.onSaved(result.versions, result.superseded)
+}
+
+// Stats возвращает счетчики очереди
+func (wq *writeBehindQueue) Stats() map[string]interface{} {
+	wq.mutex.Lock()
+	pending := len(wq.pending)
+	wq.mutex.Unlock()
+
+	return map[string]interface{}{
+		"write_queue_pending":  pending,
+		"write_queue_capacity": cap(wq.slots),
+		"writes_saved":         wq.saved.Load(),
+		"writes_coalesced":     wq.coalesced.Load(),
+		"writes_dropped":       wq.dropped.Load(),
+		"writes_failed":        wq.failed.Load(),
+		"writes_retried":       wq.retried.Load(),
+		"write_conflicts":      wq.conflicts.Load(),
+		"writes_merged":        wq.merged.Load(),
+		"writes_superseded":    wq.superseded.Load(),
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// blockingStore - хранилище в памяти, SaveBatch которого ждет release или отмены ctx
+type blockingStore struct {
+	*MemoryNavigationStore
+	started chan struct{} // Сигнал о начале записи пачки
+	release chan struct{}
+}
+
+func newBlockingStore() *blockingStore {
+	return &blockingStore{
+		MemoryNavigationStore: NewMemoryNavigationStore(),
+		started:               make(chan struct{}, 1),
+		release:               make(chan struct{}),
+	}
+}
+
+func (bs *blockingStore) SaveBatch(ctx context.Context, records map[NavigationScope]NavigationRecord) (map[NavigationScope]int64, error) {
+	select {
+	case bs.started <- struct{}{}:
+	default:
+	}
+	select {
+	case <-bs.release:
+	case <-ctx.Done():
+		return nil, storeUnavailable("save", NavigationScope{}, ctx.Err())
+	}
+
+	versions := make(map[NavigationScope]int64, len(records))
+	for scope, record := range records {
+		if version, err := bs.Save(ctx, scope, record); err == nil {
+			versions[scope] = version
+		}
+	}
+	return versions, nil
+}
+
+func TestCloseHonorsContext(t *testing.T) {
+	store := newBlockingStore()
+	pnm := NewPersistentNavigationManagerWithStore(store)
+	pnm.Start()
+
+	if err := pnm.PushMenu(context.Background(), UserScope(1), "main"); err != nil {
+		t.Fatal(err)
+	}
+	<-store.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	report, err := pnm.Close(ctx)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Close took %v with a 100ms deadline", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) || report.Abandoned != 1 {
+		t.Errorf("Close = %+v, %v, want 1 abandoned stack and deadline error", report, err)
+	}
+}
+
+func TestEraseUserWaitsForInflightBatch(t *testing.T) {
+	store := newBlockingStore()
+	pnm := NewPersistentNavigationManagerWithStore(store)
+	ctx := context.Background()
+
+	if err := pnm.PushMenu(ctx, UserScope(1), "main"); err != nil {
+		t.Fatal(err)
+	}
+	<-store.started
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := pnm.EraseUser(ctx, 1)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("EraseUser returned during an in-flight batch: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Клик во время удаления не должен попасть в хранилище
+	if err := pnm.PushMenu(ctx, UserScope(1), "settings"); err != nil {
+		t.Fatal(err)
+	}
+
+	close(store.release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pnm.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	states, err := store.ExportUser(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(states) != 0 {
+		t.Errorf("store kept %d stacks of an erased user: %+v", len(states), states)
+	}
+}
+
+func TestResolveConflictDoesNotRecreateDeleted(t *testing.T) {
+	store := NewMemoryNavigationStore()
+	wq := newWriteBehindQueue(store, newCircuitBreaker(5, time.Minute), 10)
+
+	result := saveResult{versions: map[NavigationScope]int64{}, retry: map[NavigationScope]NavigationRecord{}}
+	wq.resolveConflict(context.Background(), UserScope(1), NavigationRecord{Stack: []string{"main"}, Version: 3}, &result)
+
+	if _, err := store.Load(context.Background(), UserScope(1)); !errors.Is(err, ErrNavigationNotFound) {
+		t.Errorf("deleted stack was recreated: %v", err)
+	}
+	if len(result.superseded) != 1 || len(result.versions) != 0 {
+		t.Errorf("result = %+v, want the stack superseded", result)
+	}
+}
+
+func TestWriteQueueCoalesces(t *testing.T) {
+	store := NewMemoryNavigationStore()
+	wq := newWriteBehindQueue(store, newCircuitBreaker(5, time.Minute), 10)
+	ctx := context.Background()
+
+	for _, stack := range [][]string{{"main"}, {"main", "settings"}, {"main", "settings", "reset"}} {
+		if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: stack}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := wq.flushBatch(ctx); n != 1 {
+		t.Fatalf("flushed %d stacks, want 1", n)
+	}
+
+	record, err := store.Load(ctx, UserScope(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"main", "settings", "reset"}; !reflect.DeepEqual(record.Stack, want) {
+		t.Errorf("stored stack = %v, want %v", record.Stack, want)
+	}
+
+	stats := wq.Stats()
+	if stats["writes_saved"] != int64(1) || stats["writes_coalesced"] != int64(2) || stats["write_queue_pending"] != 0 {
+		t.Errorf("stats = %v", stats)
+	}
+	if len(wq.slots) != 0 {
+		t.Errorf("%d slots still taken", len(wq.slots))
+	}
+}
+
+func TestWriteQueueBackpressure(t *testing.T) {
+	wq := newWriteBehindQueue(NewMemoryNavigationStore(), newCircuitBreaker(5, time.Minute), 1)
+	wq.enqueueTimeout = 10 * time.Millisecond
+
+	if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: []string{"main"}}); err != nil {
+		t.Fatal(err)
+	}
+	// Тот же пользователь заменяет свой стек и места не занимает
+	if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: []string{"help"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wq.Enqueue(UserScope(2), NavigationRecord{Stack: []string{"main"}}); !errors.Is(err, ErrWriteQueueFull) {
+		t.Errorf("enqueue into a full queue = %v, want ErrWriteQueueFull", err)
+	}
+	if dropped := wq.Stats()["writes_dropped"]; dropped != int64(1) {
+		t.Errorf("writes_dropped = %v, want 1", dropped)
+	}
+
+	wq.close()
+	if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: []string{"main"}}); !errors.Is(err, ErrWriteQueueClosed) {
+		t.Errorf("enqueue after close = %v, want ErrWriteQueueClosed", err)
+	}
+}
+
+func TestWriteQueueRetriesUnavailable(t *testing.T) {
+	store := &flakyStore{MemoryNavigationStore: NewMemoryNavigationStore()}
+	wq := newWriteBehindQueue(store, newCircuitBreaker(5, time.Minute), 10)
+	ctx := context.Background()
+
+	store.down.Store(true)
+	if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: []string{"main"}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := wq.flushBatch(ctx); n != 0 {
+		t.Fatalf("flushed %d stacks into an unavailable store", n)
+	}
+	if !wq.backingOff() {
+		t.Error("queue does not back off after a failed write")
+	}
+	if _, exists := wq.Peek(UserScope(1)); !exists {
+		t.Fatal("stack left the queue after a failed write")
+	}
+
+	store.down.Store(false)
+	wq.resync()
+	if n := wq.flushBatch(ctx); n != 1 {
+		t.Fatalf("flushed %d stacks after recovery, want 1", n)
+	}
+	if _, err := store.Load(ctx, UserScope(1)); err != nil {
+		t.Errorf("stack was not written after recovery: %v", err)
+	}
+
+	stats := wq.Stats()
+	if stats["writes_retried"] != int64(1) || stats["writes_failed"] != int64(0) {
+		t.Errorf("stats = %v", stats)
+	}
+}
+
+func TestWriteQueueConflictKeepsLatestClick(t *testing.T) {
+	ctx := context.Background()
+	clicked := time.Now().UTC()
+
+	tests := []struct {
+		name      string
+		oursAt    time.Time
+		want      []string
+		stat      string
+		cacheDrop bool
+	}{
+		{name: "ours later", oursAt: clicked.Add(time.Second), want: []string{"main", "ours"}, stat: "writes_merged"},
+		{name: "stored later", oursAt: clicked.Add(-time.Second), want: []string{"main", "theirs"}, stat: "writes_superseded", cacheDrop: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := NewMemoryNavigationStore()
+			if _, err := store.Save(ctx, UserScope(1), NavigationRecord{Stack: []string{"main", "theirs"}, ClickedAt: clicked}); err != nil {
+				t.Fatal(err)
+			}
+
+			wq := newWriteBehindQueue(store, newCircuitBreaker(5, time.Minute), 10)
+			var superseded []NavigationScope
+			wq.onSaved = func(_ map[NavigationScope]int64, scopes []NavigationScope) {
+				superseded = append(superseded, scopes...)
+			}
+
+			// Стек с версией 0 не знает о записи другой реплики
+			if err := wq.Enqueue(UserScope(1), NavigationRecord{Stack: []string{"main", "ours"}, ClickedAt: tt.oursAt}); err != nil {
+				t.Fatal(err)
+			}
+			wq.flushBatch(ctx)
+
+			record, err := store.Load(ctx, UserScope(1))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(record.Stack, tt.want) {
+				t.Errorf("stored stack = %v, want %v", record.Stack, tt.want)
+			}
+			stats := wq.Stats()
+			if stats["write_conflicts"] != int64(1) || stats[tt.stat] != int64(1) {
+				t.Errorf("stats = %v", stats)
+			}
+			if (len(superseded) == 1) != tt.cacheDrop {
+				t.Errorf("superseded = %v", superseded)
+			}
+		})
+	}
+}